@@ -0,0 +1,57 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// BalanceChecker is implemented by clients that can report the balance left
+// on their provider account.
+type BalanceChecker interface {
+	Balance(ctx context.Context) (float64, error)
+}
+
+// SetBalanceThreshold sets the balance under which a client is suspended.
+func (c *CaptchaBank) SetBalanceThreshold(min float64) {
+	c.balanceThreshold = min
+}
+
+// PollBalances checks the balance of every client each interval until ctx
+// is done.
+func (c *CaptchaBank) PollBalances(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.CheckBalances(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckBalances refreshes the balance of every client and emits them in a
+// BalanceChanged event. Clients with an empty balance, or one below the threshold, are
+// suspended until it is topped up again.
+func (c *CaptchaBank) CheckBalances(ctx context.Context) {
+	balances := map[string]float64{}
+	for _, client := range c.clientList() {
+		checker, ok := client.Captcha.(BalanceChecker)
+		if !ok || client.disabled.Load() {
+			continue
+		}
+		balance, err := checker.Balance(ctx)
+		if err != nil {
+			if errors.Is(err, ErrInvalidKey) {
+				client.disabled.Store(true)
+			}
+			continue
+		}
+		client.balance.Store(balance)
+		client.suspended.Store(balance <= 0 || balance < c.balanceThreshold)
+		balances[client.Name()] = balance
+	}
+	c.emit(Event{Type: BalanceChanged, Balances: balances})
+}
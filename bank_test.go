@@ -0,0 +1,397 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"bitbucket.org/babylonaio/pkg/solver/solvertest"
+)
+
+var testTask = Task{PageURL: "https://example.com", SiteKey: "sitekey"}
+
+// newTestClient returns a createTask client of s polling every few
+// milliseconds.
+func newTestClient(s *solvertest.Server, name string) *TaskCaptcha {
+	provider := AntiCaptchaProvider
+	provider.Name = name
+	provider.Poll = PollPolicy{Initial: 5 * time.Millisecond, Interval: 5 * time.Millisecond}
+	return NewTaskCaptcha(NewTaskClient(provider, "key", s.URL, s.Client()), nil)
+}
+
+// newTestBank returns a bank harvesting testTask from s on a fast schedule.
+func newTestBank(t *testing.T, s *solvertest.Server, cfg PoolConfig) *CaptchaBank {
+	c, err := NewCaptchaBank(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddCaptchaClient(newTestClient(s, "fake"))
+	c.SetHarvestConfig(HarvestConfig{
+		MaxInFlight: 10,
+		Interval:    5 * time.Millisecond,
+		Window:      time.Second,
+		Latency:     10 * time.Millisecond,
+	})
+	c.AddPool(testTask, cfg)
+	t.Cleanup(func() { c.Stop(context.Background()) })
+	return c
+}
+
+// waitFor fails the test if cond does not hold within a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHarvestFillsPool(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{Latency: 20 * time.Millisecond})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{MinSize: 3, MaxSize: 3, Workers: 3})
+
+	c.Start()
+	p := c.getPool(testTask, false)
+	waitFor(t, "a full pool", p.full)
+
+	token, err := c.GetToken(context.Background(), testTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Provider != "fake" || token.Key != testTask.Key() || token.Expired() {
+		t.Errorf("unexpected token %+v", token)
+	}
+}
+
+func TestHarvestFillsDefaultPool(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{Latency: 10 * time.Millisecond})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{})
+
+	done := make(chan bool)
+	go func() {
+		c.Harvest(2, 5)
+		done <- true
+	}()
+	waitFor(t, "a full default pool", func() bool {
+		p := c.getPool(DefaultTask, false)
+		return p != nil && p.full()
+	})
+	c.Stop(context.Background())
+	<-done
+}
+
+func TestHarvestRetriesAfterNoSlot(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{})
+	defer s.Close()
+	s.FailSubmit("ERROR_NO_SLOT_AVAILABLE", "ERROR_NO_SLOT_AVAILABLE")
+	c := newTestBank(t, s, PoolConfig{MinSize: 1, MaxSize: 1, Workers: 1})
+
+	c.Start()
+	waitFor(t, "a token", func() bool { return !c.getPool(testTask, false).empty() })
+}
+
+func TestGetTokenWaitsForHarvest(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{Latency: 30 * time.Millisecond})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{MinSize: 1, MaxSize: 1, Workers: 1})
+	c.SetWait(WaitConfig{Budget: time.Second})
+
+	c.Start()
+	token, err := c.GetToken(context.Background(), testTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Created() > 2 {
+		t.Errorf("created %d tasks, want the harvested token", s.Created())
+	}
+	if token.Token == "" {
+		t.Error("empty token")
+	}
+}
+
+func TestTokensExpire(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{MaxSize: 1, Workers: 1})
+	c.SetExpiryPolicy(ExpiryPolicy{Default: 20 * time.Millisecond})
+	events, unsubscribe := c.Subscribe(10)
+	defer unsubscribe()
+
+	c.Start()
+	c.CreateTokenFor(testTask, "manual")
+	timeout := time.After(time.Second)
+	for expired := false; !expired; {
+		select {
+		case e := <-events:
+			if e.Type != TokenExpired {
+				continue
+			}
+			if e.Token.Token != "manual" || e.Counts["manual"] != 0 {
+				t.Errorf("unexpected event %+v", e)
+			}
+			expired = true
+		case <-timeout:
+			t.Fatal("timed out waiting for the token to expire")
+		}
+	}
+	if !c.getPool(testTask, false).empty() {
+		t.Error("expired token still pooled")
+	}
+}
+
+func TestPauseStopsHarvest(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{MinSize: 2, MaxSize: 2, Workers: 1})
+
+	c.Start()
+	c.Pause()
+	c.Pause()
+	time.Sleep(30 * time.Millisecond)
+	if n := s.Created(); n != 0 {
+		t.Fatalf("created %d tasks while paused", n)
+	}
+	c.Resume()
+	waitFor(t, "a task", func() bool { return s.Created() > 0 })
+}
+
+func TestStopCancelsInFlightSolves(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{Latency: time.Hour})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{MinSize: 1, MaxSize: 1, Workers: 1})
+
+	c.Start()
+	waitFor(t, "a solve in flight", func() bool { return c.InFlight() == 1 && s.Created() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.InFlight(); n != 0 {
+		t.Errorf("%d solves still in flight", n)
+	}
+	if c.Running() {
+		t.Error("bank still running")
+	}
+	if err := c.Stop(ctx); err != nil {
+		t.Errorf("second Stop: %v", err)
+	}
+}
+
+// blockingCaptcha solves nothing until its context is done.
+type blockingCaptcha struct{}
+
+func (blockingCaptcha) Name() string { return "blocking" }
+
+func (blockingCaptcha) Solve(ctx context.Context, task Task) (*Token, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(3 * time.Second):
+		return newAPIToken("blocking", "1", "token"), nil
+	}
+}
+
+func TestStopWhileScheduling(t *testing.T) {
+	c, err := NewCaptchaBank(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddCaptchaClient(blockingCaptcha{})
+	c.SetHarvestConfig(HarvestConfig{Interval: 50 * time.Microsecond, Window: time.Second})
+	for i := 0; i < 200; i++ {
+		task := testTask
+		task.Action = strconv.Itoa(i)
+		c.AddPool(task, PoolConfig{MinSize: 1, MaxSize: 1, Workers: 1})
+	}
+
+	c.Start()
+	waitFor(t, "a solve in flight", func() bool { return c.InFlight() > 0 })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.InFlight(); n != 0 {
+		t.Errorf("%d solves still in flight", n)
+	}
+}
+
+func TestZeroBalanceSuspendsClient(t *testing.T) {
+	empty := solvertest.NewServer(solvertest.Config{})
+	defer empty.Close()
+	empty.FailSubmit("ERROR_ZERO_BALANCE")
+	backup := solvertest.NewServer(solvertest.Config{})
+	defer backup.Close()
+
+	c, _ := NewCaptchaBank(Config{})
+	c.SetSelector(&RoundRobinSelector{})
+	c.AddCaptchaClient(newTestClient(empty, "empty"))
+	c.AddCaptchaClient(newTestClient(backup, "backup"))
+
+	token, err := c.GetTokenWithAPI(context.Background(), testTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Provider != "backup" {
+		t.Errorf("solved by %s, want backup", token.Provider)
+	}
+	if !c.clientList()[0].suspended.Load() {
+		t.Error("client with zero balance not suspended")
+	}
+}
+
+func TestTwoCaptchaErrors(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{Key: "key", Balance: 2.5})
+	defer s.Close()
+
+	client := NewTwoCaptchaClient("key", s.URL, s.Client())
+	s.FailSubmit("ERROR_ZERO_BALANCE")
+	if _, err := client.SolveTask(context.Background(), testTask, nil); !errors.Is(err, ErrZeroBalance) {
+		t.Errorf("got %v, want ErrZeroBalance", err)
+	}
+	balance, err := client.Balance(context.Background())
+	if err != nil || balance != 2.5 {
+		t.Errorf("got balance %v, %v", balance, err)
+	}
+	bad := NewTwoCaptchaClient("wrong", s.URL, s.Client())
+	if _, err := bad.SolveTask(context.Background(), testTask, nil); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("got %v, want ErrInvalidKey", err)
+	}
+	if c := NewTwoCaptchaClient("key", "", nil); c.BaseURL != TwoCaptchaURL {
+		t.Errorf("empty base url gave %q", c.BaseURL)
+	}
+}
+
+func TestTwoCaptchaPolling(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{Latency: 20 * time.Millisecond})
+	defer s.Close()
+	client := NewTwoCaptchaClient("key", s.URL, s.Client())
+	client.Poll = PollPolicy{Interval: 5 * time.Millisecond, MaxWait: time.Second}
+
+	token, err := client.SolveTask(context.Background(), testTask, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "token-"+token.TaskID {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	s.SetLatency(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.SolveTask(ctx, testTask, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context error", err)
+	}
+	client.Poll.MaxWait = 20 * time.Millisecond
+	if _, err := client.SolveTask(context.Background(), testTask, nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestPartialHarvestConfig(t *testing.T) {
+	c, _ := NewCaptchaBank(Config{})
+	c.SetHarvestConfig(HarvestConfig{MaxInFlight: 5})
+	if c.harvest.Interval != DefaultHarvestConfig.Interval || c.harvest.Window != DefaultHarvestConfig.Window {
+		t.Errorf("zero fields not defaulted: %+v", c.harvest)
+	}
+	c.Start()
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendSize(t *testing.T) {
+	c, err := NewCaptchaBank(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CreateTokenFor(testTask, "manual")
+	events, unsubscribe := c.Subscribe(1)
+	defer unsubscribe()
+
+	c.SendSize()
+	select {
+	case e := <-events:
+		if e.Type != TokenCount || e.Counts["manual"] != 1 {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+}
+
+func TestPoolExpiryOverridesPolicy(t *testing.T) {
+	c, err := NewCaptchaBank(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddPool(testTask, PoolConfig{MaxSize: 1, Expiry: 30 * time.Second})
+	c.CreateTokenFor(testTask, "manual")
+
+	tokens := c.getPool(testTask, false).tokens()
+	if len(tokens) != 1 {
+		t.Fatalf("got %d tokens, want 1", len(tokens))
+	}
+	want := 30*time.Second - DefaultExpiryPolicy.Margin
+	if got := tokens[0].ExpiresAt.Sub(tokens[0].Created); got != want {
+		t.Errorf("lifetime = %v, want %v", got, want)
+	}
+}
+
+func TestReplaceClientsWhileRunning(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{Latency: time.Millisecond})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{MinSize: 5, MaxSize: 5, Workers: 2})
+
+	c.Start()
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		c.Clear()
+		c.AddCaptchaClient(newTestClient(s, "fake"))
+		c.SetSelector(&RoundRobinSelector{})
+		c.SetCircuitBreaker(5, time.Minute)
+		c.CheckBalances(context.Background())
+		time.Sleep(time.Millisecond)
+	}
+	waitFor(t, "a token", func() bool { return !c.getPool(testTask, false).empty() })
+}
+
+func TestIPBanIsTemporary(t *testing.T) {
+	for _, code := range []string{"ERROR_IP_BLOCKED", "IP_BANNED"} {
+		err := newProviderError("fake", code)
+		if !errors.Is(err, ErrProviderDown) || fatal(err) {
+			t.Errorf("%s gave %v, want a retryable ErrProviderDown", code, err)
+		}
+	}
+}
+
+func TestPushStampsExpiry(t *testing.T) {
+	c, err := NewCaptchaBank(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Push(&Token{Token: "lost", Key: testTask.Key()}); !errors.Is(err, ErrNoPool) {
+		t.Errorf("got %v, want ErrNoPool", err)
+	}
+	c.AddPool(testTask, PoolConfig{MaxSize: 2})
+	if err := c.Push(&Token{Token: "fresh", Type: "manual", Key: testTask.Key()}); err != nil {
+		t.Fatal(err)
+	}
+	old := &Token{Token: "old", Key: testTask.Key(), Created: time.Now().Add(-time.Hour)}
+	if err := c.Push(old); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("got %v, want ErrTokenExpired", err)
+	}
+	tokens := c.getPool(testTask, false).tokens()
+	if len(tokens) != 1 || tokens[0].Token != "fresh" || tokens[0].ExpiresAt.IsZero() {
+		t.Errorf("unexpected pool %+v", tokens)
+	}
+}
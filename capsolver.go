@@ -0,0 +1,24 @@
+package solver
+
+import (
+	"time"
+)
+
+// CapSolverProvider is the configuration of https://capsolver.com/
+var CapSolverProvider = TaskProvider{
+	Name:    "capsolver",
+	BaseURL: "https://api.capsolver.com",
+	TaskTypes: map[CaptchaKind]TaskType{
+		RecaptchaV2:          {Proxy: "ReCaptchaV2Task", Proxyless: "ReCaptchaV2TaskProxyLess"},
+		RecaptchaV2Invisible: {Proxy: "ReCaptchaV2Task", Proxyless: "ReCaptchaV2TaskProxyLess"},
+		RecaptchaV3:          {Proxy: "ReCaptchaV3Task", Proxyless: "ReCaptchaV3TaskProxyLess"},
+		RecaptchaEnterprise:  {Proxy: "ReCaptchaV2EnterpriseTask", Proxyless: "ReCaptchaV2EnterpriseTaskProxyLess"},
+		HCaptcha:             {Proxy: "HCaptchaTask", Proxyless: "HCaptchaTaskProxyLess"},
+	},
+	Poll: PollPolicy{
+		Initial:  3 * time.Second,
+		Interval: 2 * time.Second,
+		MaxWait:  120 * time.Second,
+		Jitter:   500 * time.Millisecond,
+	},
+}
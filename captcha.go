@@ -2,142 +2,101 @@ package solver
 
 import (
 	"context"
-	"fmt"
-	"sync"
-	"time"
-
-	d "bitbucket.org/babylonaio/pkg/datastore"
+	"io"
 )
 
+// Captcha is a solver backed by a captcha provider. Solve returns the solved
+// token, or an error wrapping one of the sentinel errors in errors.go so
+// callers can decide whether to retry, rotate or disable the client.
 type Captcha interface {
-	Solve(ctx context.Context) string
+	Name() string
+	Solve(ctx context.Context, task Task) (*Token, error)
 }
 
+// TwoCaptcha solves captchas through 2captcha, using the proxies of its
+// pool.
 type TwoCaptcha struct {
+	proxyRotation
 	client *TwoCaptchaClient
 }
 
-type AntiCaptcha struct {
-	client  *AntiCaptchaClient
-	proxies []*d.Proxy
-	index   int
-	mu      sync.RWMutex
+func InitTwoCaptcha(key string) *TwoCaptcha {
+	return NewTwoCaptchaSolver(NewTwoCaptcha(key), nil)
 }
 
-type Capmonster struct {
-	client  *CapmonsterClient
-	proxies []*d.Proxy
-	index   int
-	mu      sync.RWMutex
+// NewTwoCaptchaSolver wraps client, using the proxies of pool. A nil pool
+// solves proxyless unless the task has a proxy.
+func NewTwoCaptchaSolver(client *TwoCaptchaClient, pool *ProxyPool) *TwoCaptcha {
+	return &TwoCaptcha{client: client, proxyRotation: proxyRotation{pool: pool}}
 }
 
-var datadomeURL = "https://geo.captcha-delivery.com"
-var siteKey = "6LccSjEUAAAAANCPhaM2c-WiRxCZ5CzsjR_vd8uX"
+func (c *TwoCaptcha) Name() string {
+	return twoCaptchaName
+}
 
-func InitTwoCaptcha(key string) *TwoCaptcha {
-	return &TwoCaptcha{client: NewTwoCaptcha(key)}
-}
-
-func (c *TwoCaptcha) Solve(ctx context.Context) string {
-	token, err := c.client.SolveRecaptchaV2(ctx, datadomeURL, siteKey)
-	if err != nil {
-		fmt.Println("ERROR: ", err.Error())
-		return ""
-	}
-	return token
-}
-
-func InitCapmonster(key string, pg string) *Capmonster {
-	if d.DStore.ProxyGroups != nil && len(d.DStore.ProxyGroups) > 0 && pg != "" {
-		for _, p := range d.DStore.ProxyGroups {
-			if p.ID == pg {
-				return &Capmonster{client: InitCapmonsterClient(key), proxies: p.Proxies}
-			}
-		}
-
-	}
-	return &Capmonster{client: InitCapmonsterClient(key), proxies: make([]*d.Proxy, 0)}
-}
-
-func (c *Capmonster) Solve(ctx context.Context) string {
-REDO:
-	var prox *d.Proxy
-	if len(c.proxies) > 0 {
-		c.mu.RLock()
-		prox = c.proxies[c.index]
-		c.mu.RUnlock()
-	}
-	token := ""
-	if prox != nil {
-		t, err := c.client.CreateToken(ctx, datadomeURL, siteKey, prox.Host, prox.Port, prox.Username, prox.Password)
-		if err != nil {
-			return ""
-		}
-		token = t
-	} else {
-		t, err := c.client.CreateToken(ctx, datadomeURL, siteKey, "", "", "", "")
-		if err != nil {
-			return ""
-		}
-		token = t
-	}
-	if token == "" && len(c.proxies) > 0 {
-		c.RotateProxy()
-		goto REDO
-	}
-	return token
-}
-
-func (c *Capmonster) RotateProxy() {
-	c.mu.Lock()
-	c.index = (c.index + 1) % len(c.proxies)
-	c.mu.Unlock()
-}
-
-func (a *AntiCaptcha) RotateProxy() {
-	a.mu.Lock()
-	a.index = (a.index + 1) % len(a.proxies)
-	a.mu.Unlock()
-}
-
-func InitAntiCaptcha(key, pg string) *AntiCaptcha {
-	if d.DStore.ProxyGroups != nil && len(d.DStore.ProxyGroups) > 0 {
-		for _, p := range d.DStore.ProxyGroups {
-			if p.ID == pg {
-				return &AntiCaptcha{client: &AntiCaptchaClient{APIKey: key}, proxies: p.Proxies}
-			}
-		}
-
-	}
-	return &AntiCaptcha{client: &AntiCaptchaClient{APIKey: key}, proxies: make([]*d.Proxy, 0)}
-}
-func (c *AntiCaptcha) Solve(ctx context.Context) string {
-REDO:
-	var prox *d.Proxy
-	if len(c.proxies) > 0 {
-		c.mu.RLock()
-		prox = c.proxies[c.index]
-		c.mu.RUnlock()
-	}
-	token := ""
-	if prox != nil {
-		t, err := c.client.SendRecaptcha(ctx, datadomeURL, siteKey, 30*time.Second, prox.Host, prox.Port, prox.Username, prox.Password)
-		if err != nil {
-			fmt.Println("ERROR: ", err.Error())
-			return ""
-		}
-		token = t
-	} else {
-		t, err := c.client.SendRecaptcha(ctx, datadomeURL, siteKey, 30*time.Second, "", "", "", "")
-		if err != nil {
-			fmt.Println("ERROR: ", err.Error())
-			return ""
-		}
-		token = t
-	}
-	if token == "" && len(c.proxies) > 0 {
-		c.RotateProxy()
-		goto REDO
-	}
-	return token
-}
\ No newline at end of file
+func (c *TwoCaptcha) Balance(ctx context.Context) (float64, error) {
+	return c.client.Balance(ctx)
+}
+
+func (c *TwoCaptcha) Solve(ctx context.Context, task Task) (*Token, error) {
+	return c.proxyRotation.solve(ctx, task, c.client.SolveTask)
+}
+
+func (c *TwoCaptcha) SolveImage(ctx context.Context, r io.Reader, opts ImageOptions) (*Token, error) {
+	return c.client.SolveImage(ctx, r, opts)
+}
+
+func (c *TwoCaptcha) Report(ctx context.Context, t *Token, ok bool) error {
+	return c.client.Report(ctx, t.TaskID, ok)
+}
+
+// TaskCaptcha solves captchas through a createTask style provider such as
+// anti-captcha or capmonster, using the proxies of its pool.
+type TaskCaptcha struct {
+	proxyRotation
+	client *TaskClient
+}
+
+// InitCapmonster creates a capmonster client using the proxies of the
+// d.DStore proxy group pg.
+func InitCapmonster(key string, pg string) *TaskCaptcha {
+	return InitTaskCaptcha(InitCapmonsterClient(key), pg)
+}
+
+// InitAntiCaptcha creates an anti-captcha client using the proxies of the
+// d.DStore proxy group pg.
+func InitAntiCaptcha(key, pg string) *TaskCaptcha {
+	return InitTaskCaptcha(NewAntiCaptchaClient(key), pg)
+}
+
+// InitTaskCaptcha wraps client, using the proxies of the d.DStore proxy
+// group pg.
+func InitTaskCaptcha(client *TaskClient, pg string) *TaskCaptcha {
+	return NewTaskCaptcha(client, NewProxyPool(DStoreProxies{}.Proxies(pg)))
+}
+
+// NewTaskCaptcha wraps client, using the proxies of pool. A nil pool solves
+// proxyless unless the task has a proxy.
+func NewTaskCaptcha(client *TaskClient, pool *ProxyPool) *TaskCaptcha {
+	return &TaskCaptcha{client: client, proxyRotation: proxyRotation{pool: pool}}
+}
+
+func (c *TaskCaptcha) Name() string {
+	return c.client.Provider.Name
+}
+
+func (c *TaskCaptcha) Balance(ctx context.Context) (float64, error) {
+	return c.client.Balance(ctx)
+}
+
+func (c *TaskCaptcha) SolveImage(ctx context.Context, r io.Reader, opts ImageOptions) (*Token, error) {
+	return c.client.SolveImage(ctx, r, opts)
+}
+
+func (c *TaskCaptcha) Report(ctx context.Context, t *Token, ok bool) error {
+	return c.client.Report(ctx, t.TaskID, ok)
+}
+
+func (c *TaskCaptcha) Solve(ctx context.Context, task Task) (*Token, error) {
+	return c.proxyRotation.solve(ctx, task, c.client.SolveTask)
+}
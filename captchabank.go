@@ -2,290 +2,501 @@ package solver
 
 import (
 	"context"
-	"fmt"
-	"math/rand"
+	"errors"
 	"sync"
 	"time"
 
 	d "bitbucket.org/babylonaio/pkg/datastore"
-	"bitbucket.org/babylonaio/pkg/utils"
 	astilectron "github.com/asticode/go-astilectron"
 	atom "github.com/uber-go/atomic"
 )
 
 type CaptchaBank struct {
-	ch            chan *Token
-	manualCh      chan *Token
-	done          chan bool
-	stopProcess   chan bool
-	stopFilter    chan bool
-	pause         chan bool
-	resume        chan bool
-	clients       []Captcha
-	w             *astilectron.Window
-	counter       atom.Int32
-	threadCount   atom.Int32
-	manualCounter atom.Int32
-	maxSize       int32
-	queue         *utils.Queue
-	Running       bool
-	cancelFuncs   []context.CancelFunc
-	cancelMu      *sync.Mutex
+	// state is guarded by stateMu. stop is closed by Stop to end the harvest
+	// loop, which closes finished once it returned. The harvest solves run
+	// on ctx, cancelled by Stop before stop is closed.
+	stateMu  sync.Mutex
+	state    State
+	stop     chan struct{}
+	finished chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// workers counts the harvest solves started by the loop.
+	workers sync.WaitGroup
+	// wake reschedules the expiry timer when a token expiring before the
+	// current deadline is added.
+	wake chan struct{}
+	// clientsMu guards clients, which is replaced rather than modified, and
+	// the settings used to pick among them.
+	clientsMu sync.RWMutex
+	clients   []*bankClient
+	selector  Selector
+	// breakerThreshold consecutive failures open the circuit breaker of a
+	// client for breakerCooldown.
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	race             RaceConfig
+	balanceThreshold float64
+	store            Store
+	wait             WaitConfig
+	consume          ConsumePolicy
+	// harvest and expiry are guarded by stateMu.
+	harvest     HarvestConfig
+	expiry      ExpiryPolicy
+	proxyPool   ProxyPoolConfig
+	proxyModes  ProxyModes
+	listeners   []Listener
+	listenersMu sync.RWMutex
+	threadCount atom.Int32
+	pools       map[string]*pool
+	poolsMu     *sync.RWMutex
+	cancelFuncs map[uint64]context.CancelFunc
+	cancelID    uint64
+	cancelMu    *sync.Mutex
 }
 
 type Token struct {
 	Token   string
 	Created time.Time
-	Type    string
+	// ExpiresAt is set from the ExpiryPolicy of the bank.
+	ExpiresAt time.Time
+	Type      string
+	// Key is the Task.Key of the pool the token belongs to.
+	Key string
+	// Provider and TaskID identify the solve that produced an api token so
+	// it can be reported back with Report.
+	Provider string
+	TaskID   string
 }
 
+// CaptchaB is the bank of the Init functions, kept for the callers that
+// predate NewCaptchaBank.
 var CaptchaB *CaptchaBank
 
+func newAPIToken(provider, taskID, token string) *Token {
+	return &Token{Token: token, Created: time.Now(), Type: "api", Provider: provider, TaskID: taskID}
+}
+
 func InitCaptchaBank(window *astilectron.Window) {
-	CaptchaB = &CaptchaBank{
-		ch:          make(chan *Token),
-		manualCh:    make(chan *Token),
-		done:        make(chan bool),
-		stopProcess: make(chan bool),
-		stopFilter:  make(chan bool),
-		pause:       make(chan bool),
-		resume:      make(chan bool),
-		queue:       utils.NewQueue(),
-		cancelFuncs: []context.CancelFunc{},
-		cancelMu:    &sync.Mutex{},
-		w:           window,
+	InitCaptchaBankWithStore(window, nil)
+}
+
+// InitCaptchaBankWithStore initialises CaptchaB, reloading the tokens saved
+// in store. A nil store disables persistence.
+func InitCaptchaBankWithStore(window *astilectron.Window, store Store) error {
+	cfg := Config{Store: store}
+	if window != nil {
+		cfg.Listeners = append(cfg.Listeners, NewElectronListener(window))
 	}
+	var err error
+	CaptchaB, err = NewCaptchaBank(cfg)
+	return err
 }
 
+// InitCaptchaBankClients sets the clients of CaptchaB from the settings and
+// proxy groups of d.DStore.
 func InitCaptchaBankClients(proxygroup string) {
-	if d.DStore.Settings != nil {
-		if d.DStore.Settings.Captcha != nil {
-			CaptchaB.Clear()
-			if d.DStore.Settings.Captcha.TwoCaptcha != "" {
-				CaptchaB.AddCaptchaClient(InitTwoCaptcha(d.DStore.Settings.Captcha.TwoCaptcha))
-			}
-			if d.DStore.Settings.Captcha.AntiCaptcha != "" {
-				CaptchaB.AddCaptchaClient(InitAntiCaptcha(d.DStore.Settings.Captcha.AntiCaptcha, proxygroup))
-			}
-			if d.DStore.Settings.Captcha.CapMonster != "" {
-				CaptchaB.AddCaptchaClient(InitCapmonster(d.DStore.Settings.Captcha.CapMonster, proxygroup))
-			}
-		}
+	if d.DStore.Settings == nil || d.DStore.Settings.Captcha == nil {
+		return
 	}
+	s := d.DStore.Settings.Captcha
+	CaptchaB.SetKeys(Keys{
+		TwoCaptcha:  s.TwoCaptcha,
+		AntiCaptcha: s.AntiCaptcha,
+		CapMonster:  s.CapMonster,
+	}, DStoreProxies{}.Proxies(proxygroup))
 }
 
-func (c *CaptchaBank) SendSize() {
-	m := map[string]int32{}
-	m["api"] = c.counter.Load()
-	m["manual"] = c.manualCounter.Load()
-	payload := map[string]interface{}{
-		"name":    "captchabank-tokens",
-		"payload": m,
+func newCaptchaBank(store Store) *CaptchaBank {
+	return &CaptchaBank{
+		wake:             make(chan struct{}, 1),
+		pools:            map[string]*pool{},
+		poolsMu:          &sync.RWMutex{},
+		cancelFuncs:      map[uint64]context.CancelFunc{},
+		cancelMu:         &sync.Mutex{},
+		selector:         WeightedSelector{},
+		breakerThreshold: 5,
+		breakerCooldown:  time.Minute,
+		store:            store,
+		expiry:           DefaultExpiryPolicy,
+		wait:             DefaultWaitConfig,
+		consume:          DefaultConsumePolicy,
+		harvest:          DefaultHarvestConfig,
+		proxyPool:        DefaultProxyPoolConfig,
 	}
-	c.w.SendMessage(payload, func(m *astilectron.EventMessage) {
-		return
-	})
 }
 
 func (c *CaptchaBank) Empty() bool {
-	return c.counter.Load() == 0 && c.manualCounter.Load() == 0
+	for _, p := range c.poolList() {
+		if !p.empty() {
+			return false
+		}
+	}
+	return true
 }
 
-func (c *CaptchaBank) Push(t *Token) {
-	c.queue.Append(t)
+// AddPool configures the token pool of task, creating it if needed. Pools
+// are harvested independently and GetToken only draws from the pool whose
+// key matches the requested task.
+func (c *CaptchaBank) AddPool(task Task, cfg PoolConfig) {
+	c.poolsMu.Lock()
+	defer c.poolsMu.Unlock()
+	if p, ok := c.pools[task.Key()]; ok {
+		p.setConfig(cfg)
+		return
+	}
+	c.pools[task.Key()] = newPool(task, cfg)
 }
 
-func (c *CaptchaBank) PushCancelFunc(f context.CancelFunc) {
-	c.cancelMu.Lock()
-	c.cancelFuncs = append(c.cancelFuncs, f)
-	c.cancelMu.Unlock()
+// getPool returns the pool of task, creating one with DefaultPoolConfig if
+// create is set.
+func (c *CaptchaBank) getPool(task Task, create bool) *pool {
+	key := task.Key()
+	c.poolsMu.RLock()
+	p, ok := c.pools[key]
+	c.poolsMu.RUnlock()
+	if ok || !create {
+		return p
+	}
+	c.poolsMu.Lock()
+	defer c.poolsMu.Unlock()
+	if p, ok := c.pools[key]; ok {
+		return p
+	}
+	p = newPool(task, DefaultPoolConfig)
+	c.pools[key] = p
+	return p
 }
 
-func (c *CaptchaBank) GetToken() string {
-	if c.counter.Load() == 0 && c.manualCounter.Load() == 0 {
-		return ""
+func (c *CaptchaBank) poolList() []*pool {
+	c.poolsMu.RLock()
+	defer c.poolsMu.RUnlock()
+	pools := make([]*pool, 0, len(c.pools))
+	for _, p := range c.pools {
+		pools = append(pools, p)
 	}
-	for {
-		t := c.queue.Pop()
-		if t == nil {
-			return ""
+	return pools
+}
+
+// Push adds t to the pool whose key is t.Key. A token without an expiry
+// gets one from the expiry policy of the bank, counted from Created, or
+// from now if Created is not set either.
+func (c *CaptchaBank) Push(t *Token) error {
+	c.poolsMu.RLock()
+	p, ok := c.pools[t.Key]
+	c.poolsMu.RUnlock()
+	if !ok {
+		return ErrNoPool
+	}
+	if t.ExpiresAt.IsZero() {
+		if t.Created.IsZero() {
+			t.Created = time.Now()
 		}
-		token := t.(*Token)
-		if token != nil {
-			if token.Type == "api" {
-				c.counter.Dec()
-			} else {
-				c.manualCounter.Dec()
-			}
-			go c.SendSize()
-			if time.Since(token.Created).Milliseconds() < 119000 {
-				return token.Token
-			}
+		c.expiryPolicy().apply(t, p.task, p.config())
+	}
+	if t.Expired() {
+		return ErrTokenExpired
+	}
+	c.add(p, t)
+	return nil
+}
+
+// add stores t in p and reschedules the expiry timer if t is the next token
+// of the pool to expire.
+func (c *CaptchaBank) add(p *pool, t *Token) {
+	if p.push(t) {
+		select {
+		case c.wake <- struct{}{}:
+		default:
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
+	c.emit(Event{Type: TokenAdded, Key: t.Key, Token: t})
+}
+
+// GetToken returns a harvested token for task, chosen by the consume
+// policy of the bank. If the pool has none it waits for the next harvested
+// token, first come first served, until ctx is done or the wait budget runs
+// out, then falls back to GetTokenWithAPI if enabled.
+func (c *CaptchaBank) GetToken(ctx context.Context, task Task) (*Token, error) {
+	return c.GetTokenWithPolicy(ctx, task, c.consume)
+}
 
+// waitToken pops a token from p, waiting up to the wait budget for one.
+func (c *CaptchaBank) waitToken(ctx context.Context, p *pool, policy ConsumePolicy) *Token {
+	t, e := p.popOrWait(policy, c.wait.Budget > 0)
+	if e == nil {
+		return t
+	}
+	timer := time.NewTimer(c.wait.Budget)
+	defer timer.Stop()
+	select {
+	case t := <-e.Value.(*waiter).ch:
+		return t
+	case <-timer.C:
+		return p.cancelWait(e)
+	case <-ctx.Done():
+		if t := p.cancelWait(e); t != nil {
+			c.add(p, t)
+		}
+		return nil
+	}
 }
 
-func (c *CaptchaBank) Filter() {
+// expireTokens drops expired tokens as they expire. A single timer is armed
+// for the next expiry across all pools and rearmed when add signals a token
+// expiring earlier.
+func (c *CaptchaBank) expireTokens(stop <-chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
 	for {
+		var next time.Time
+		for _, p := range c.poolList() {
+			n, expired := p.expire()
+			for _, t := range expired {
+				c.emit(Event{Type: TokenExpired, Key: t.Key, Token: t})
+			}
+			if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+				next = n
+			}
+		}
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
 		select {
-		case <-c.stopFilter:
+		case <-stop:
 			return
-		default:
-			if c.queue.Length() > 0 {
-				i := c.queue.Length()
-				for i > 0 {
-					f := c.queue.Front()
-					if f != nil {
-						token := c.queue.Pop().(*Token)
-						if time.Since(token.Created).Milliseconds() > 119000 {
-							c.queue.Remove(f)
-							if token.Type == "api" {
-								c.counter.Dec()
-							} else {
-								c.manualCounter.Dec()
-							}
-						} else {
-							c.Push(token)
-						}
-					}
-					i--
-				}
-				go c.SendSize()
-			}
-			time.Sleep(4 * time.Second)
+		case <-c.wake:
+		case <-timer.C:
 		}
 	}
 }
 
+// WaitConfig configures how GetToken behaves when the pool is empty.
+type WaitConfig struct {
+	// Budget is how long GetToken waits for a harvested token. Zero does
+	// not wait.
+	Budget time.Duration
+	// Fallback solves a token on demand when none arrived in time.
+	Fallback bool
+}
+
+// DefaultWaitConfig does not wait and falls back to an on-demand solve.
+var DefaultWaitConfig = WaitConfig{Fallback: true}
+
+// SetWait configures how GetToken behaves when the pool is empty.
+func (c *CaptchaBank) SetWait(cfg WaitConfig) {
+	c.wait = cfg
+}
+
 func (cb *CaptchaBank) AddCaptchaClient(c Captcha) {
-	cb.clients = append(cb.clients, c)
+	cb.AddWeightedCaptchaClient(c, 1)
+}
+
+// AddWeightedCaptchaClient adds a client with the given share of traffic,
+// used by WeightedSelector.
+func (cb *CaptchaBank) AddWeightedCaptchaClient(c Captcha, weight float64) {
+	cb.clientsMu.Lock()
+	defer cb.clientsMu.Unlock()
+	clients := make([]*bankClient, len(cb.clients), len(cb.clients)+1)
+	copy(clients, cb.clients)
+	cb.clients = append(clients, newBankClient(c, weight))
+}
+
+// SetSelector sets the strategy used to pick a client for each solve.
+func (cb *CaptchaBank) SetSelector(s Selector) {
+	cb.clientsMu.Lock()
+	cb.selector = s
+	cb.clientsMu.Unlock()
+}
+
+// SetCircuitBreaker sets how many consecutive failures take a client out of
+// rotation and for how long before a probe solve is tried again.
+func (cb *CaptchaBank) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	cb.clientsMu.Lock()
+	cb.breakerThreshold = threshold
+	cb.breakerCooldown = cooldown
+	cb.clientsMu.Unlock()
 }
 
 func (cb *CaptchaBank) Clear() {
-	cb.clients = []Captcha{}
+	cb.setClients(nil)
 }
 
-func (c *CaptchaBank) Stop() {
-	c.Running = false
-	go func() {
-		c.done <- true
-		c.stopProcess <- true
-		c.stopFilter <- true
-	}()
+// setClients replaces the clients of the bank at once.
+func (cb *CaptchaBank) setClients(clients []*bankClient) {
+	cb.clientsMu.Lock()
+	cb.clients = clients
+	cb.clientsMu.Unlock()
 }
 
-func (c *CaptchaBank) Pause() {
-	go func() {
-		c.pause <- true
-	}()
+// clientList returns the clients of the bank. The slice must not be
+// modified.
+func (cb *CaptchaBank) clientList() []*bankClient {
+	cb.clientsMu.RLock()
+	defer cb.clientsMu.RUnlock()
+	return cb.clients
 }
 
-func (c *CaptchaBank) Resume() {
-	go func() {
-		c.resume <- true
-	}()
+// breaker returns the circuit breaker settings set by SetCircuitBreaker.
+func (cb *CaptchaBank) breaker() (int, time.Duration) {
+	cb.clientsMu.RLock()
+	defer cb.clientsMu.RUnlock()
+	return cb.breakerThreshold, cb.breakerCooldown
 }
 
+// Harvest configures the pool of DefaultTask to keep maxSize tokens ready
+// with up to workers solves at once, starts the bank and blocks until it is
+// stopped.
 func (c *CaptchaBank) Harvest(workers, maxSize float64) {
-	c.maxSize = int32(maxSize)
-	c.Running = true
-	go c.ProcessTokens()
-	for {
-		select {
-		case <-c.done:
-			fmt.Println("finished")
-			return
-		case <-c.pause:
-			fmt.Println("pausing")
-			select {
-			case <-c.resume:
-				fmt.Println("resuming")
-			case <-c.done:
-				fmt.Println("finished")
-				return
-			}
-		default:
-			for i := 0; i < int(workers); i++ {
-				go c.CreateTokenWithAPI()
-				c.threadCount.Inc()
-			}
-			time.Sleep(2000 * time.Millisecond)
-		}
+	cfg := DefaultPoolConfig
+	if p := c.getPool(DefaultTask, false); p != nil {
+		cfg = p.config()
 	}
+	cfg.MaxSize, cfg.MinSize, cfg.Workers = int32(maxSize), int32(maxSize), int(workers)
+	c.AddPool(DefaultTask, cfg)
+	c.Start()
+	c.stateMu.Lock()
+	finished := c.finished
+	c.stateMu.Unlock()
+	<-finished
 }
 
+// CreateToken adds a manually solved token to the pool of DefaultTask.
 func (c *CaptchaBank) CreateToken(token string) {
-	c.manualCounter.Inc()
-	go c.SendSize()
-	c.manualCh <- &Token{Token: token, Created: time.Now(), Type: "manual"}
+	c.CreateTokenFor(DefaultTask, token)
+}
+
+// CreateTokenFor adds a manually solved token to the pool of task.
+func (c *CaptchaBank) CreateTokenFor(task Task, token string) {
+	t := &Token{Token: token, Created: time.Now(), Type: "manual", Key: task.Key()}
+	p := c.getPool(task, true)
+	c.expiryPolicy().apply(t, task, p.config())
+	c.add(p, t)
+}
+
+func (c *CaptchaBank) CreateTokenWithAPI(task Task) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer c.PushCancelFunc(cancel)()
+	c.createToken(ctx, task, "")
 }
 
-func (c *CaptchaBank) CreateTokenWithAPI() {
-	if c.counter.Load() >= c.maxSize {
+// createToken solves task into its pool on ctx. A non empty worker tags the
+// solve for Sticky proxy pools.
+func (c *CaptchaBank) createToken(ctx context.Context, task Task, worker string) {
+	p := c.getPool(task, true)
+	if p.full() {
 		return
 	}
-	l := len(c.clients)
-	if l == 0 {
+	if len(c.clientList()) == 0 {
 		return
 	}
+	if worker != "" {
+		ctx = WithWorker(ctx, worker)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	c.PushCancelFunc(cancel)
-
-	r := rand.Intn(l)
-	c.threadCount.Dec()
-	t := c.clients[r].Solve(ctx)
-	if t == "" || c.counter.Load() >= c.maxSize {
+	t, err := c.solve(ctx, task)
+	if err != nil || p.full() {
 		return
 	}
-	c.counter.Inc()
-	go c.SendSize()
-	c.ch <- &Token{Token: t, Created: time.Now(), Type: "api"}
+	t.Key = task.Key()
+	c.add(p, t)
 }
 
-func (c *CaptchaBank) GetTokenWithAPI(ctx context.Context) string {
-	l := len(c.clients)
-	if l == 0 {
-		return ""
+// GetTokenWithAPI solves task on demand, racing several clients if
+// SetRace was called.
+func (c *CaptchaBank) GetTokenWithAPI(ctx context.Context, task Task) (*Token, error) {
+	if c.race.Providers > 1 {
+		return c.raceSolve(ctx, task)
 	}
+	return c.solve(ctx, task)
+}
 
-	r := rand.Intn(l)
-	t := c.clients[r].Solve(ctx)
-	if t == "" {
-		return ""
+// solve asks the clients picked by the selector for a token. Clients that
+// report a zero balance or an invalid key are disabled, and transient errors
+// move on to the next client.
+func (c *CaptchaBank) solve(ctx context.Context, task Task) (*Token, error) {
+	err := ErrNoClients
+	tried := map[*bankClient]bool{}
+	for {
+		client := c.pick(tried)
+		if client == nil {
+			return nil, err
+		}
+		tried[client] = true
+		var t *Token
+		t, err = c.solveWith(ctx, client, task)
+		if err == nil {
+			return t, nil
+		}
+		if ctx.Err() != nil || !(retryable(err) || fatal(err)) {
+			return nil, err
+		}
 	}
-	return t
 }
 
-func (c *CaptchaBank) ProcessTokens() {
-	go c.Filter()
-	for {
-		select {
-		case <-c.stopProcess:
-			c.cancelMu.Lock()
-			for _, f := range c.cancelFuncs {
-				go f()
-			}
-			c.cancelFuncs = []context.CancelFunc{}
-			c.cancelMu.Unlock()
-			return
-		case t := <-c.ch:
-			if time.Since(t.Created).Milliseconds() < 119000 {
-				c.Push(t)
-				continue
-			}
-			c.counter.Dec()
-		case t := <-c.manualCh:
-			if time.Since(t.Created).Milliseconds() < 119000 {
-				c.Push(t)
-				continue
-			}
-			c.manualCounter.Dec()
-		default:
+// solveWith runs a single solve on client and records its outcome.
+func (c *CaptchaBank) solveWith(ctx context.Context, client *bankClient, task Task) (*Token, error) {
+	c.emit(Event{Type: SolveStarted, Key: task.Key(), Provider: client.Name()})
+	start := time.Now()
+	t, err := client.Solve(ctx, task)
+	if err != nil {
+		c.emit(Event{Type: SolveFailed, Key: task.Key(), Provider: client.Name(), Err: err})
+	}
+	if err != nil && (ctx.Err() != nil || errors.Is(err, ErrUnsupportedTask) || errors.Is(err, ErrNoProxy)) {
+		client.release()
+		return nil, err
+	}
+	threshold, cooldown := c.breaker()
+	client.record(time.Since(start), err, threshold, cooldown)
+	if err == nil {
+		cfg := DefaultPoolConfig
+		if p := c.getPool(task, false); p != nil {
+			cfg = p.config()
+		}
+		c.expiryPolicy().apply(t, task, cfg)
+	}
+	if errors.Is(err, ErrZeroBalance) {
+		client.suspended.Store(true)
+	} else if fatal(err) {
+		client.disabled.Store(true)
+	}
+	return t, err
+}
+
+// pick returns the client chosen by the selector among the enabled clients
+// whose circuit breaker lets a solve through, skipping those in exclude.
+func (c *CaptchaBank) pick(exclude map[*bankClient]bool) *bankClient {
+	c.clientsMu.RLock()
+	clients, selector, threshold := c.clients, c.selector, c.breakerThreshold
+	c.clientsMu.RUnlock()
+	var candidates []*bankClient
+	var stats []ClientStats
+	for _, client := range clients {
+		if exclude[client] || client.disabled.Load() || client.suspended.Load() || !client.available(threshold) {
 			continue
 		}
+		candidates = append(candidates, client)
+		stats = append(stats, client.stats())
+	}
+	for len(candidates) > 0 {
+		i := selector.Select(stats)
+		if i < 0 || i >= len(candidates) {
+			i = 0
+		}
+		if candidates[i].acquire(threshold) {
+			return candidates[i]
+		}
+		candidates = append(candidates[:i], candidates[i+1:]...)
+		stats = append(stats[:i], stats[i+1:]...)
 	}
+	return nil
 }
@@ -0,0 +1,110 @@
+package solver
+
+import (
+	d "bitbucket.org/babylonaio/pkg/datastore"
+)
+
+// ProxySource looks up the proxies of a proxy group.
+type ProxySource interface {
+	Proxies(group string) []*Proxy
+}
+
+// StaticProxies is a ProxySource backed by a map of groups.
+type StaticProxies map[string][]*Proxy
+
+func (s StaticProxies) Proxies(group string) []*Proxy {
+	return s[group]
+}
+
+// DStoreProxies is the ProxySource of the proxy groups in d.DStore. The
+// datastore does not record proxy types, so all proxies are of Type.
+type DStoreProxies struct {
+	Type ProxyType
+}
+
+func (s DStoreProxies) Proxies(group string) []*Proxy {
+	if group == "" {
+		return nil
+	}
+	for _, p := range d.DStore.ProxyGroups {
+		if p.ID == group {
+			return NewProxies(p.Proxies, s.Type)
+		}
+	}
+	return nil
+}
+
+// Keys holds the API keys of the supported providers. Providers with an
+// empty key are not used.
+type Keys struct {
+	TwoCaptcha  string
+	AntiCaptcha string
+	CapMonster  string
+}
+
+// ProxyModes holds the ProxyMode of the client of each provider.
+type ProxyModes struct {
+	TwoCaptcha  ProxyMode
+	AntiCaptcha ProxyMode
+	CapMonster  ProxyMode
+}
+
+// Config configures a bank created by NewCaptchaBank.
+type Config struct {
+	Keys Keys
+	// ProxyGroup is looked up in Proxies for the proxies of the clients. A
+	// nil Proxies solves proxyless.
+	ProxyGroup string
+	Proxies    ProxySource
+	// ProxyPool configures the pool the clients share the proxies through.
+	// Nil uses DefaultProxyPoolConfig.
+	ProxyPool *ProxyPoolConfig
+	// ProxyModes chooses between proxied and proxyless solves per provider.
+	ProxyModes ProxyModes
+	// Store persists the pools, nil disables persistence.
+	Store     Store
+	Listeners []Listener
+}
+
+// NewCaptchaBank creates a bank with the clients of cfg.Keys and reloads the
+// tokens saved in cfg.Store. The bank is returned even if reloading failed.
+func NewCaptchaBank(cfg Config) (*CaptchaBank, error) {
+	c := newCaptchaBank(cfg.Store)
+	if cfg.ProxyPool != nil {
+		c.proxyPool = *cfg.ProxyPool
+	}
+	c.proxyModes = cfg.ProxyModes
+	for _, l := range cfg.Listeners {
+		c.AddListener(l)
+	}
+	var proxies []*Proxy
+	if cfg.Proxies != nil {
+		proxies = cfg.Proxies.Proxies(cfg.ProxyGroup)
+	}
+	c.SetKeys(cfg.Keys, proxies)
+	return c, c.restore()
+}
+
+// SetKeys replaces the clients of the bank by those of keys, all sharing a
+// pool of proxies and using the proxy modes given to NewCaptchaBank. It is
+// safe to call while the bank runs.
+func (c *CaptchaBank) SetKeys(keys Keys, proxies []*Proxy) {
+	pool := NewProxyPoolWithConfig(proxies, c.proxyPool)
+	var clients []*bankClient
+	if keys.TwoCaptcha != "" {
+		client := NewTwoCaptchaSolver(NewTwoCaptcha(keys.TwoCaptcha), pool)
+		client.SetProxyMode(c.proxyModes.TwoCaptcha)
+		clients = append(clients, newBankClient(client, 1))
+	}
+	if keys.AntiCaptcha != "" {
+		client := NewTaskCaptcha(NewAntiCaptchaClient(keys.AntiCaptcha), pool)
+		client.SetProxyMode(c.proxyModes.AntiCaptcha)
+		clients = append(clients, newBankClient(client, 1))
+	}
+	if keys.CapMonster != "" {
+		client := NewTaskCaptcha(InitCapmonsterClient(keys.CapMonster), pool)
+		client.SetProxyMode(c.proxyModes.CapMonster)
+		clients = append(clients, newBankClient(client, 1))
+	}
+	c.setClients(clients)
+}
@@ -0,0 +1,73 @@
+package solver
+
+import (
+	"context"
+	"time"
+)
+
+// ConsumeOrder decides which pooled token GetToken hands out first.
+type ConsumeOrder int
+
+const (
+	// OldestFirst hands out the token closest to its expiry, so tokens are
+	// used before they go to waste.
+	OldestFirst ConsumeOrder = iota
+	// FreshestFirst hands out the token with the most life left, for
+	// checkouts that run long after the token is taken.
+	FreshestFirst
+)
+
+// ConsumePolicy decides which pooled token a consumer gets.
+type ConsumePolicy struct {
+	Order ConsumeOrder
+	// MinRemaining skips tokens with less life left. They stay in the pool
+	// for consumers asking for less.
+	MinRemaining time.Duration
+}
+
+// DefaultConsumePolicy hands out the oldest usable token.
+var DefaultConsumePolicy = ConsumePolicy{Order: OldestFirst}
+
+// pick returns the index of the token chosen by policy, or -1 if no token
+// has enough life left.
+func (h tokenHeap) pick(policy ConsumePolicy) int {
+	deadline := time.Now().Add(policy.MinRemaining)
+	best := -1
+	for i, t := range h {
+		if !deadline.Before(t.ExpiresAt) {
+			continue
+		}
+		switch {
+		case best < 0:
+			best = i
+		case policy.Order == FreshestFirst && h[best].ExpiresAt.Before(t.ExpiresAt):
+			best = i
+		case policy.Order == OldestFirst && t.ExpiresAt.Before(h[best].ExpiresAt):
+			best = i
+		}
+	}
+	return best
+}
+
+// SetConsumePolicy sets the policy used by GetToken.
+func (c *CaptchaBank) SetConsumePolicy(policy ConsumePolicy) {
+	c.consume = policy
+}
+
+// GetTokenWithPolicy is GetToken with a consume policy of the caller's own,
+// for consumers that need a token to last longer than the bank default.
+func (c *CaptchaBank) GetTokenWithPolicy(ctx context.Context, task Task, policy ConsumePolicy) (*Token, error) {
+	if p := c.getPool(task, false); p != nil {
+		if t := c.waitToken(ctx, p, policy); t != nil {
+			c.emit(Event{Type: TokenConsumed, Key: t.Key, Token: t})
+			return t, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if !c.wait.Fallback {
+		return nil, ErrNoToken
+	}
+	return c.GetTokenWithAPI(ctx, task)
+}
@@ -24,16 +24,25 @@ type CaptchaHarvester struct {
 	Cookie     string `json:"cookie"`
 }
 
+// SolveDatadome solves the DataDome captcha with a token of CaptchaB.
 func SolveDatadome(ctx context.Context, sbody, datadomeURL, datadomeCid, userAgent, pUrl, siteUrl, siteKey string, cookies []*http.Cookie) string {
+	return CaptchaB.SolveDatadome(ctx, sbody, datadomeURL, datadomeCid, userAgent, pUrl, siteUrl, siteKey, cookies)
+}
+
+// SolveDatadome solves the DataDome captcha with a token of the bank.
+func (c *CaptchaBank) SolveDatadome(ctx context.Context, sbody, datadomeURL, datadomeCid, userAgent, pUrl, siteUrl, siteKey string, cookies []*http.Cookie) string {
 	captchaUrl, cid, hsh, b := ParseBuildDatadomeURL(sbody, datadomeCid, datadomeURL, siteUrl)
 	if captchaUrl == "" {
 		return "rotate"
 	}
-	ddurl, cookies := getBuildUrl(ctx, sbody, captchaUrl, datadomeURL, datadomeCid, cid, hsh, b, userAgent, pUrl, siteUrl, siteKey, cookies)
+	ddurl, cookies, token := getBuildUrl(ctx, c, sbody, captchaUrl, datadomeURL, datadomeCid, cid, hsh, b, userAgent, pUrl, siteUrl, siteKey, cookies)
 	if ddurl == "" {
 		return ""
 	}
-	cos := getDDUrl(ddurl, cookies)
+	cos, answered := getDDUrl(ddurl, cookies)
+	if answered {
+		go c.Report(token, cos != "")
+	}
 	return cos
 
 }
@@ -77,7 +86,7 @@ func ParseBuildDatadomeURL(body string, datadomeCid, datadomeURL, url string) (s
 	return fmt.Sprintf("%s/captcha/?initialCid=%s&hash=%s&cid=%s&t=%s&referrer=%s&s=%s", datadomeURL, cid, hsh, datadomeCid, t, url, b), cid, hsh, b
 }
 
-func getBuildUrl(ctx context.Context, sbody, captchaUrl, datadomeURL, datadomeCid, cid, hsh, b, userAgent, pUrl, siteUrl, siteKey string, cookies []*http.Cookie) (string, []*http.Cookie) {
+func getBuildUrl(ctx context.Context, bank *CaptchaBank, sbody, captchaUrl, datadomeURL, datadomeCid, cid, hsh, b, userAgent, pUrl, siteUrl, siteKey string, cookies []*http.Cookie) (string, []*http.Cookie, *Token) {
 
 	req, err := http.NewRequest("GET", captchaUrl, nil)
 	req.Header.Set("host", "geo.captcha-delivery.com")
@@ -85,30 +94,36 @@ func getBuildUrl(ctx context.Context, sbody, captchaUrl, datadomeURL, datadomeCi
 		req.AddCookie(co)
 	}
 	if err != nil {
-		return "", cookies
+		return "", cookies, nil
 	}
 	resp, err := connect.DefaultDo(req)
 	if err != nil {
-		return "", cookies
+		return "", cookies, nil
 	}
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
 	sbody = string(body)
 	if strings.Contains(sbody, "g-recaptcha-response") {
-		token := CaptchaB.GetToken()
-		if token == "" {
-			token = CaptchaB.GetTokenWithAPI(ctx)
-			if token == "" {
-				return "", cookies
-			}
+		task := DefaultTask
+		task.UserAgent = userAgent
+		token, err := bank.GetToken(ctx, task)
+		if err != nil {
+			log.Println(err.Error())
+			return "", cookies, nil
 		}
-		return strings.ReplaceAll(fmt.Sprintf("%s/captcha/check?cid=%s&icid=%s&ccid=null&g-recaptcha-response=%s&hash=%s&ua=%s&referer=%s&parent_url=%s&x-forwarded-for=%s&s=%s", datadomeURL, datadomeCid, cid, token, hsh, userAgent, siteUrl, pUrl, "", b), " ", "%20"), resp.Cookies()
+		return strings.ReplaceAll(fmt.Sprintf("%s/captcha/check?cid=%s&icid=%s&ccid=null&g-recaptcha-response=%s&hash=%s&ua=%s&referer=%s&parent_url=%s&x-forwarded-for=%s&s=%s", datadomeURL, datadomeCid, cid, token.Token, hsh, userAgent, siteUrl, pUrl, "", b), " ", "%20"), resp.Cookies(), token
 	}
-	return "", cookies
+	return "", cookies, nil
 }
 
-func getDDUrl(url string, cookies []*http.Cookie) string {
+// getDDUrl submits the captcha check and returns the cookie DataDome set.
+// answered is false if DataDome could not be reached or failed to judge the
+// token, which then says nothing about the token.
+func getDDUrl(url string, cookies []*http.Cookie) (cookie string, answered bool) {
 	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", false
+	}
 	req.Header.Set("host", "geo.captcha-delivery.com")
 	for _, co := range cookies {
 		req.AddCookie(co)
@@ -116,11 +131,14 @@ func getDDUrl(url string, cookies []*http.Cookie) string {
 	resp, err := connect.DefaultDo(req)
 	if err != nil {
 		log.Println(err.Error())
-		return ""
+		return "", false
 	}
 	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	return gjson.Get(string(body), "cookie").String()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode >= 500 {
+		return "", false
+	}
+	return gjson.Get(string(body), "cookie").String(), true
 }
 
 //}
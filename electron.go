@@ -0,0 +1,62 @@
+package solver
+
+import (
+	astilectron "github.com/asticode/go-astilectron"
+)
+
+// electronBuffer is the number of messages an ElectronListener queues before
+// dropping new ones.
+const electronBuffer = 64
+
+// ElectronListener forwards the token counts and balances of a bank to an
+// astilectron window as "captchabank-tokens" and "captchabank-balance"
+// messages. Messages are sent in order from a goroutine of the listener so
+// the bank never waits for the window; they are dropped while the queue is
+// full.
+type ElectronListener struct {
+	w        *astilectron.Window
+	messages chan map[string]interface{}
+}
+
+// NewElectronListener creates a listener sending to w until Close.
+func NewElectronListener(w *astilectron.Window) *ElectronListener {
+	l := &ElectronListener{
+		w:        w,
+		messages: make(chan map[string]interface{}, electronBuffer),
+	}
+	go l.run()
+	return l
+}
+
+func (l *ElectronListener) OnEvent(e Event) {
+	switch e.Type {
+	case TokenAdded, TokenConsumed, TokenExpired, TokenCount:
+		l.send("captchabank-tokens", e.Counts)
+	case BalanceChanged:
+		l.send("captchabank-balance", e.Balances)
+	}
+}
+
+// Close stops the listener once the queued messages are sent. Remove it
+// from the bank first.
+func (l *ElectronListener) Close() {
+	close(l.messages)
+}
+
+func (l *ElectronListener) send(name string, payload interface{}) {
+	select {
+	case l.messages <- map[string]interface{}{
+		"name":    name,
+		"payload": payload,
+	}:
+	default:
+	}
+}
+
+func (l *ElectronListener) run() {
+	for m := range l.messages {
+		l.w.SendMessage(m, func(m *astilectron.EventMessage) {
+			return
+		})
+	}
+}
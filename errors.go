@@ -0,0 +1,108 @@
+package solver
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrZeroBalance is returned when the provider account has no funds left.
+	ErrZeroBalance = errors.New("captcha: zero balance")
+	// ErrInvalidKey is returned when the provider rejects the API key.
+	ErrInvalidKey = errors.New("captcha: invalid api key")
+	// ErrNoSlotAvailable is returned when the provider has no free workers.
+	ErrNoSlotAvailable = errors.New("captcha: no slot available")
+	// ErrTimeout is returned when a solve does not finish in time.
+	ErrTimeout = errors.New("captcha: timeout")
+	// ErrUnsolvable is returned when the provider gives up on a captcha.
+	ErrUnsolvable = errors.New("captcha: unsolvable")
+	// ErrProviderDown is returned when the provider cannot be reached or
+	// answers with something we do not understand.
+	ErrProviderDown = errors.New("captcha: provider down")
+	// ErrUnsupportedTask is returned when a provider cannot solve the kind
+	// of captcha asked for.
+	ErrUnsupportedTask = errors.New("captcha: unsupported task")
+	// ErrBadProxy is returned when the provider could not use the proxy of
+	// the task.
+	ErrBadProxy = errors.New("captcha: bad proxy")
+	// ErrNoProxy is returned by clients in ProxyRequired mode when a solve
+	// has no proxy to go through.
+	ErrNoProxy = errors.New("captcha: no proxy available")
+	// ErrNoToken is returned by GetToken when no token arrived in time and
+	// falling back to an on-demand solve is disabled.
+	ErrNoToken = errors.New("captcha: no token available")
+	// ErrNoClients is returned by the bank when no usable client is left.
+	ErrNoClients = errors.New("captcha: no clients available")
+	// ErrNoPool is returned by Push for a token whose Key matches no pool.
+	ErrNoPool = errors.New("captcha: no pool for token")
+	// ErrTokenExpired is returned by Push for a token already expired.
+	ErrTokenExpired = errors.New("captcha: token expired")
+)
+
+// ProviderError carries the raw error code returned by a provider together
+// with the sentinel error it maps to.
+type ProviderError struct {
+	Provider string
+	Code     string
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Provider + ": " + e.Code + ": " + e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// providerErrors maps the error codes shared by 2captcha, anti-captcha and
+// capmonster to sentinel errors. IP bans lift after a few minutes, so they
+// are ErrProviderDown and leave the client to its circuit breaker.
+var providerErrors = map[string]error{
+	"ERROR_ZERO_BALANCE":              ErrZeroBalance,
+	"ERROR_WRONG_USER_KEY":            ErrInvalidKey,
+	"ERROR_KEY_DOES_NOT_EXIST":        ErrInvalidKey,
+	"ERROR_IP_NOT_ALLOWED":            ErrInvalidKey,
+	"ERROR_IP_BLOCKED":                ErrProviderDown,
+	"IP_BANNED":                       ErrProviderDown,
+	"ERROR_NO_SLOT_AVAILABLE":         ErrNoSlotAvailable,
+	"ERROR_CAPTCHA_UNSOLVABLE":        ErrUnsolvable,
+	"ERROR_BAD_DUPLICATES":            ErrUnsolvable,
+	"ERROR_RECAPTCHA_INVALID_SITEKEY": ErrUnsolvable,
+	"ERROR_RECAPTCHA_INVALID_DOMAIN":  ErrUnsolvable,
+	"ERROR_WRONG_GOOGLEKEY":           ErrUnsolvable,
+	"ERROR_GOOGLEKEY":                 ErrUnsolvable,
+	"ERROR_PAGEURL":                   ErrUnsolvable,
+	"ERROR_RECAPTCHA_TIMEOUT":         ErrTimeout,
+	"ERROR_MAXIMUM_TIME_EXCEED":       ErrTimeout,
+	"ERROR_TASK_ABSENT":               ErrProviderDown,
+	"ERROR_NO_SUCH_CAPCHA_ID":         ErrProviderDown,
+	"ERROR_WRONG_CAPTCHA_ID":          ErrProviderDown,
+	"ERROR_BAD_PROXY":                 ErrBadProxy,
+}
+
+// newProviderError maps a provider error code to a *ProviderError. The
+// ERROR_PROXY_* codes are ErrBadProxy, other unknown codes are treated as
+// ErrProviderDown.
+func newProviderError(provider, code string) error {
+	code = strings.TrimSpace(code)
+	err, ok := providerErrors[code]
+	switch {
+	case ok:
+	case strings.HasPrefix(code, "ERROR_PROXY_"):
+		err = ErrBadProxy
+	default:
+		err = ErrProviderDown
+	}
+	return &ProviderError{Provider: provider, Code: code, Err: err}
+}
+
+// retryable reports whether the solve can be retried on another client.
+func retryable(err error) bool {
+	return errors.Is(err, ErrNoSlotAvailable) || errors.Is(err, ErrProviderDown) || errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnsupportedTask) || errors.Is(err, ErrNoProxy) || errors.Is(err, ErrBadProxy)
+}
+
+// fatal reports whether the client should be disabled after err.
+func fatal(err error) bool {
+	return errors.Is(err, ErrZeroBalance) || errors.Is(err, ErrInvalidKey)
+}
@@ -0,0 +1,144 @@
+package solver
+
+// EventType identifies what an Event reports.
+type EventType int
+
+const (
+	// TokenAdded is emitted when a harvested or manual token enters a pool.
+	TokenAdded EventType = iota
+	// TokenConsumed is emitted when GetToken hands out a pooled token.
+	TokenConsumed
+	// TokenExpired is emitted when a pooled token is dropped on expiry.
+	TokenExpired
+	// SolveStarted and SolveFailed report the solves sent to a provider.
+	SolveStarted
+	SolveFailed
+	// BalanceChanged is emitted after CheckBalances with every balance read.
+	BalanceChanged
+	// TokenCount is emitted by SendSize with the current token counts.
+	TokenCount
+)
+
+func (t EventType) String() string {
+	switch t {
+	case TokenAdded:
+		return "token_added"
+	case TokenConsumed:
+		return "token_consumed"
+	case TokenExpired:
+		return "token_expired"
+	case SolveStarted:
+		return "solve_started"
+	case SolveFailed:
+		return "solve_failed"
+	case BalanceChanged:
+		return "balance_changed"
+	case TokenCount:
+		return "token_count"
+	}
+	return "unknown"
+}
+
+// Event is something that happened in a CaptchaBank. Only the fields
+// relevant to its Type are set.
+type Event struct {
+	Type EventType
+	// Key is the Task.Key of the pool or solve.
+	Key      string
+	Token    *Token
+	Provider string
+	Err      error
+	// Counts holds the "api" and "manual" tokens across all pools after a
+	// token or TokenCount event.
+	Counts map[string]int32
+	// Balances holds the balance of every client checked, by name.
+	Balances map[string]float64
+}
+
+// Listener receives the events of a bank. OnEvent is called synchronously
+// from the goroutine that caused the event and must neither block nor add
+// or remove listeners.
+type Listener interface {
+	OnEvent(e Event)
+}
+
+// ListenerFunc adapts a function to a Listener.
+type ListenerFunc func(e Event)
+
+func (f ListenerFunc) OnEvent(e Event) {
+	f(e)
+}
+
+// AddListener registers l for the events of the bank.
+func (c *CaptchaBank) AddListener(l Listener) {
+	c.listenersMu.Lock()
+	c.listeners = append(c.listeners, l)
+	c.listenersMu.Unlock()
+}
+
+// RemoveListener unregisters l.
+func (c *CaptchaBank) RemoveListener(l Listener) {
+	c.listenersMu.Lock()
+	defer c.listenersMu.Unlock()
+	for i, other := range c.listeners {
+		if other == l {
+			c.listeners = append(c.listeners[:i:i], c.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// chanListener forwards events to a channel, dropping them when it is full.
+type chanListener struct {
+	ch chan Event
+}
+
+func (l *chanListener) OnEvent(e Event) {
+	select {
+	case l.ch <- e:
+	default:
+	}
+}
+
+// Subscribe returns a channel receiving the events of the bank. Events are
+// dropped while the buffer of size events is full. The returned function
+// unsubscribes and closes the channel.
+func (c *CaptchaBank) Subscribe(size int) (<-chan Event, func()) {
+	l := &chanListener{ch: make(chan Event, size)}
+	c.AddListener(l)
+	return l.ch, func() {
+		c.RemoveListener(l)
+		close(l.ch)
+	}
+}
+
+// emit sends e to the listeners, adding the token counts to token events.
+func (c *CaptchaBank) emit(e Event) {
+	c.listenersMu.RLock()
+	defer c.listenersMu.RUnlock()
+	if len(c.listeners) == 0 {
+		return
+	}
+	switch e.Type {
+	case TokenAdded, TokenConsumed, TokenExpired, TokenCount:
+		e.Counts = c.counts()
+	}
+	for _, l := range c.listeners {
+		l.OnEvent(e)
+	}
+}
+
+// SendSize emits a TokenCount event with the tokens across all pools.
+func (c *CaptchaBank) SendSize() {
+	c.emit(Event{Type: TokenCount})
+}
+
+// counts returns the api and manual tokens across all pools.
+func (c *CaptchaBank) counts() map[string]int32 {
+	m := map[string]int32{}
+	for _, p := range c.poolList() {
+		m["api"] += p.counter.Load()
+		m["manual"] += p.manualCounter.Load()
+	}
+	return m
+}
@@ -0,0 +1,75 @@
+package solver
+
+import (
+	"time"
+)
+
+// ExpiryPolicy decides how long a token stays usable after it was solved.
+// A provider lifetime takes precedence over a kind lifetime, which takes
+// precedence over Default. The Expiry of a PoolConfig overrides them all
+// for the tokens of that pool.
+type ExpiryPolicy struct {
+	Default   time.Duration
+	Kinds     map[CaptchaKind]time.Duration
+	Providers map[string]time.Duration
+	// Margin is taken off every lifetime to leave time for the checkout
+	// that uses the token.
+	Margin time.Duration
+}
+
+// DefaultExpiryPolicy keeps tokens for the two minutes a reCAPTCHA v2 token
+// is valid, minus one second.
+var DefaultExpiryPolicy = ExpiryPolicy{
+	Default: 120 * time.Second,
+	Margin:  time.Second,
+}
+
+// Lifetime returns how long a token of kind solved by provider stays usable.
+func (e ExpiryPolicy) Lifetime(kind CaptchaKind, provider string) time.Duration {
+	lifetime := e.Default
+	if d, ok := e.Kinds[kind]; ok {
+		lifetime = d
+	}
+	if d, ok := e.Providers[provider]; ok {
+		lifetime = d
+	}
+	return lifetime - e.Margin
+}
+
+// poolLifetime returns the lifetime of the tokens of kind solved by provider
+// for a pool configured with cfg, whose Expiry takes precedence.
+func (e ExpiryPolicy) poolLifetime(cfg PoolConfig, kind CaptchaKind, provider string) time.Duration {
+	if cfg.Expiry > 0 {
+		return cfg.Expiry - e.Margin
+	}
+	return e.Lifetime(kind, provider)
+}
+
+// apply sets the expiry of t, a token solved for task into a pool configured
+// with cfg.
+func (e ExpiryPolicy) apply(t *Token, task Task, cfg PoolConfig) {
+	t.ExpiresAt = t.Created.Add(e.poolLifetime(cfg, task.kind(), t.Provider))
+}
+
+// Expired reports whether the token is past its expiry.
+func (t *Token) Expired() bool {
+	return !time.Now().Before(t.ExpiresAt)
+}
+
+// Remaining returns how long the token stays usable.
+func (t *Token) Remaining() time.Duration {
+	return time.Until(t.ExpiresAt)
+}
+
+// SetExpiryPolicy sets the policy used for the tokens solved from now on.
+func (c *CaptchaBank) SetExpiryPolicy(e ExpiryPolicy) {
+	c.stateMu.Lock()
+	c.expiry = e
+	c.stateMu.Unlock()
+}
+
+func (c *CaptchaBank) expiryPolicy() ExpiryPolicy {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.expiry
+}
@@ -0,0 +1,140 @@
+package solver
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+)
+
+// HarvestConfig configures the scheduler that keeps the pools topped up.
+//
+// Every Interval the scheduler measures how many tokens of each pool were
+// asked for and how many expired, and keeps enough tokens in the pool to
+// cover that demand for a token lifetime, bounded by the MinSize and
+// MaxSize of the pool. Solves are started early enough to cover what is
+// used up while they run, using the average solve latency of the clients.
+type HarvestConfig struct {
+	// MaxInFlight caps the solves running at once across all pools. Zero
+	// leaves only the Workers limit of each pool.
+	MaxInFlight int
+	Interval    time.Duration
+	// Window is roughly the period the demand and expiry rates are
+	// averaged over.
+	Window time.Duration
+	// Latency is the solve latency assumed until a client solved once.
+	Latency time.Duration
+}
+
+// DefaultHarvestConfig averages demand over two minutes and assumes solves
+// take 30 seconds.
+var DefaultHarvestConfig = HarvestConfig{
+	MaxInFlight: 20,
+	Interval:    time.Second,
+	Window:      2 * time.Minute,
+	Latency:     30 * time.Second,
+}
+
+// SetHarvestConfig configures the harvest scheduler. Zero durations take
+// the value of DefaultHarvestConfig. It takes effect the next time the bank
+// is started.
+func (c *CaptchaBank) SetHarvestConfig(cfg HarvestConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultHarvestConfig.Interval
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultHarvestConfig.Window
+	}
+	if cfg.Latency <= 0 {
+		cfg.Latency = DefaultHarvestConfig.Latency
+	}
+	c.stateMu.Lock()
+	c.harvest = cfg
+	c.stateMu.Unlock()
+}
+
+// InFlight returns the number of harvest solves currently running.
+func (c *CaptchaBank) InFlight() int {
+	return int(c.threadCount.Load())
+}
+
+// schedule starts the solves each pool needs to reach its target size, on
+// ctx. It starts none once ctx is done.
+func (c *CaptchaBank) schedule(ctx context.Context, cfg HarvestConfig) {
+	expiry := c.expiryPolicy()
+	latency := c.latency(cfg.Latency)
+	alpha := math.Min(1, float64(cfg.Interval)/float64(cfg.Window))
+	for _, p := range c.poolList() {
+		p.updateRates(cfg.Interval, alpha)
+		pcfg := p.config()
+		lifetime := expiry.poolLifetime(pcfg, p.task.kind(), "")
+		target := p.target(pcfg, lifetime)
+		drain := int32(math.Ceil((p.demandRate + p.expireRate) * latency.Seconds()))
+		need := target + drain - p.size() - p.inFlight.Load()
+		for ; need > 0 && p.inFlight.Load() < int32(pcfg.Workers); need-- {
+			if ctx.Err() != nil || cfg.MaxInFlight > 0 && c.threadCount.Load() >= int32(cfg.MaxInFlight) {
+				return
+			}
+			c.startSolve(ctx, p)
+		}
+	}
+}
+
+// startSolve runs a harvest solve for p, counted as in flight until it ends.
+// The solve runs as the lowest free worker slot of the pool, so that a
+// Sticky proxy pool keeps each slot on its proxy.
+func (c *CaptchaBank) startSolve(ctx context.Context, p *pool) {
+	p.inFlight.Inc()
+	c.threadCount.Inc()
+	c.workers.Add(1)
+	slot := p.takeSlot()
+	go func() {
+		defer c.workers.Done()
+		defer c.threadCount.Dec()
+		defer p.inFlight.Dec()
+		defer p.releaseSlot(slot)
+		c.createToken(ctx, p.task, p.task.Key()+"#"+strconv.Itoa(slot))
+	}()
+}
+
+// latency returns the average solve latency of the usable clients, or
+// fallback if none solved yet.
+func (c *CaptchaBank) latency(fallback time.Duration) time.Duration {
+	var total time.Duration
+	n := 0
+	for _, client := range c.clientList() {
+		if client.disabled.Load() || client.suspended.Load() {
+			continue
+		}
+		if l := client.stats().Latency; l > 0 {
+			total += l
+			n++
+		}
+	}
+	if n == 0 {
+		return fallback
+	}
+	return total / time.Duration(n)
+}
+
+// updateRates folds the requests and expiries counted since the last call,
+// interval ago, into the per second rates of the pool.
+func (p *pool) updateRates(interval time.Duration, alpha float64) {
+	demand := float64(p.demand.Swap(0)) / interval.Seconds()
+	expired := float64(p.expired.Swap(0)) / interval.Seconds()
+	p.demandRate = alpha*demand + (1-alpha)*p.demandRate
+	p.expireRate = alpha*expired + (1-alpha)*p.expireRate
+}
+
+// target returns the number of tokens to keep in the pool: what is asked for
+// during a token lifetime, bounded by the MinSize and MaxSize of cfg.
+func (p *pool) target(cfg PoolConfig, lifetime time.Duration) int32 {
+	target := int32(math.Ceil(p.demandRate * lifetime.Seconds()))
+	if target < cfg.MinSize {
+		target = cfg.MinSize
+	}
+	if target > cfg.MaxSize {
+		target = cfg.MaxSize
+	}
+	return target
+}
@@ -0,0 +1,40 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+)
+
+// ImageOptions describes the expected answer of an image captcha. Zero
+// values leave the provider defaults.
+type ImageOptions struct {
+	CaseSensitive bool
+	// Numeric restricts the answer to digits.
+	Numeric   bool
+	MinLength int
+	MaxLength int
+	// Language is the language code of the text, such as "en" or "ru".
+	Language string
+}
+
+// ImageSolver is implemented by clients that can solve image captchas.
+type ImageSolver interface {
+	SolveImage(ctx context.Context, r io.Reader, opts ImageOptions) (*Token, error)
+}
+
+// readImage returns the content of r encoded in base64.
+func readImage(r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// SolveImageBytes solves the image captcha held in b with s.
+func SolveImageBytes(ctx context.Context, s ImageSolver, b []byte, opts ImageOptions) (*Token, error) {
+	return s.SolveImage(ctx, bytes.NewReader(b), opts)
+}
@@ -0,0 +1,144 @@
+package solver
+
+import (
+	"context"
+	"time"
+)
+
+// State is the harvest state of a CaptchaBank.
+type State int32
+
+const (
+	Stopped State = iota
+	Running
+	Paused
+)
+
+func (s State) String() string {
+	switch s {
+	case Running:
+		return "running"
+	case Paused:
+		return "paused"
+	default:
+		return "stopped"
+	}
+}
+
+// State returns the harvest state of the bank.
+func (c *CaptchaBank) State() State {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// Running reports whether the bank is harvesting or paused.
+func (c *CaptchaBank) Running() bool {
+	return c.State() != Stopped
+}
+
+// Start harvests tokens for every pool, as scheduled by the HarvestConfig
+// of the bank, until Stop is called. It reports false if the bank was
+// already started.
+func (c *CaptchaBank) Start() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.state != Stopped {
+		return false
+	}
+	c.state = Running
+	c.stop = make(chan struct{})
+	c.finished = make(chan struct{})
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run(c.ctx, c.stop, c.finished, c.harvest)
+	return true
+}
+
+// Stop stops harvesting, cancels the solves in flight and saves the pools.
+// It waits for the harvest workers to finish until ctx is done; the pools
+// are then saved as they are and ctx.Err() is returned. Stopping a stopped
+// bank does nothing.
+func (c *CaptchaBank) Stop(ctx context.Context) error {
+	c.stateMu.Lock()
+	if c.state == Stopped {
+		c.stateMu.Unlock()
+		return nil
+	}
+	c.state = Stopped
+	c.cancel()
+	close(c.stop)
+	finished := c.finished
+	c.stateMu.Unlock()
+
+	c.cancelMu.Lock()
+	for _, f := range c.cancelFuncs {
+		f()
+	}
+	c.cancelMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		<-finished
+		c.workers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		c.Save()
+		return ctx.Err()
+	}
+	return c.Save()
+}
+
+// Pause stops starting new solves until Resume. Solves in flight carry on.
+func (c *CaptchaBank) Pause() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.state == Running {
+		c.state = Paused
+	}
+}
+
+// Resume undoes Pause.
+func (c *CaptchaBank) Resume() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.state == Paused {
+		c.state = Running
+	}
+}
+
+// run is the harvest loop started by Start, scheduled by cfg, whose solves
+// run on ctx. It closes finished once stop is closed.
+func (c *CaptchaBank) run(ctx context.Context, stop, finished chan struct{}, cfg HarvestConfig) {
+	defer close(finished)
+	go c.expireTokens(stop)
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if c.State() == Running {
+				c.schedule(ctx, cfg)
+			}
+		}
+	}
+}
+
+// PushCancelFunc registers f to be called by Stop. The returned function
+// removes the registration, once the solve f cancels completed.
+func (c *CaptchaBank) PushCancelFunc(f context.CancelFunc) func() {
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	c.cancelID++
+	id := c.cancelID
+	c.cancelFuncs[id] = f
+	return func() {
+		c.cancelMu.Lock()
+		delete(c.cancelFuncs, id)
+		c.cancelMu.Unlock()
+	}
+}
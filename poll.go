@@ -0,0 +1,57 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// PollPolicy decides when a client polls a provider for the result of a
+// task. Every wait is lengthened by a random duration up to Jitter so that
+// concurrent solves do not poll in lockstep.
+type PollPolicy struct {
+	// Initial is the delay before the first poll and Interval the delay
+	// between the following ones.
+	Initial  time.Duration
+	Interval time.Duration
+	// MaxWait is the time after which the task is abandoned with
+	// ErrTimeout. Zero waits until the context is done.
+	MaxWait time.Duration
+	Jitter  time.Duration
+}
+
+// poll calls check as scheduled by the policy until it reports the task
+// done or fails, ctx is done or MaxWait elapsed. name is the provider
+// reported in the timeout error.
+func (p PollPolicy) poll(ctx context.Context, name string, check func() (bool, error)) error {
+	var timeout <-chan time.Time
+	if p.MaxWait > 0 {
+		t := time.NewTimer(p.MaxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	wait := time.NewTimer(p.jitter(p.Initial))
+	defer wait.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("%w: %s check result timeout", ErrTimeout, name)
+		case <-wait.C:
+		}
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		wait.Reset(p.jitter(p.Interval))
+	}
+}
+
+func (p PollPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(int64(p.Jitter)))
+}
@@ -0,0 +1,226 @@
+package solver
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"time"
+
+	atom "github.com/uber-go/atomic"
+)
+
+// PoolConfig configures the token pool of a single task.
+type PoolConfig struct {
+	// MaxSize is the most harvested tokens kept in the pool, whatever the
+	// demand.
+	MaxSize int32
+	// MinSize is the number of tokens kept ready even without demand.
+	MinSize int32
+	// Workers caps the harvest solves of the pool running at once.
+	Workers int
+	// Expiry overrides the lifetime the ExpiryPolicy of the bank gives the
+	// tokens of the pool. Zero uses the policy.
+	Expiry time.Duration
+}
+
+// DefaultPoolConfig is used for pools that were not configured with AddPool.
+var DefaultPoolConfig = PoolConfig{
+	MaxSize: 10,
+	Workers: 1,
+}
+
+// tokenHeap orders tokens by expiry, the first to expire on top.
+type tokenHeap []*Token
+
+func (h tokenHeap) Len() int            { return len(h) }
+func (h tokenHeap) Less(i, j int) bool  { return h[i].ExpiresAt.Before(h[j].ExpiresAt) }
+func (h tokenHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *tokenHeap) Push(x interface{}) { *h = append(*h, x.(*Token)) }
+func (h *tokenHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return t
+}
+
+// pool holds the tokens harvested for one task. The counters mirror the
+// content of the heap so they can be read without taking the lock.
+type pool struct {
+	task Task
+	mu   sync.Mutex
+	// cfg may be changed by AddPool while the bank runs, use config.
+	cfg  PoolConfig
+	heap tokenHeap
+	// waiters holds a *waiter for every consumer blocked in GetToken, in
+	// arrival order. Pushed tokens go to the first waiter they satisfy
+	// instead of the heap.
+	waiters       list.List
+	counter       atom.Int32
+	manualCounter atom.Int32
+
+	// demand and expired count the tokens asked for and dropped since the
+	// last harvest round, which folds them into demandRate and expireRate.
+	demand     atom.Int32
+	expired    atom.Int32
+	demandRate float64
+	expireRate float64
+	inFlight   atom.Int32
+	// slots marks the worker slots taken by harvest solves, guarded by mu.
+	slots []bool
+}
+
+// waiter is a consumer waiting for a token with at least min life left.
+type waiter struct {
+	ch  chan *Token
+	min time.Duration
+}
+
+func newPool(task Task, cfg PoolConfig) *pool {
+	return &pool{task: task, cfg: cfg}
+}
+
+// config returns the current configuration of the pool.
+func (p *pool) config() PoolConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cfg
+}
+
+func (p *pool) setConfig(cfg PoolConfig) {
+	p.mu.Lock()
+	p.cfg = cfg
+	p.mu.Unlock()
+}
+
+// takeSlot marks the lowest free worker slot as taken and returns it.
+func (p *pool) takeSlot() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, taken := range p.slots {
+		if !taken {
+			p.slots[i] = true
+			return i
+		}
+	}
+	p.slots = append(p.slots, true)
+	return len(p.slots) - 1
+}
+
+func (p *pool) releaseSlot(slot int) {
+	p.mu.Lock()
+	p.slots[slot] = false
+	p.mu.Unlock()
+}
+
+func (p *pool) full() bool {
+	return p.counter.Load() >= p.config().MaxSize
+}
+
+func (p *pool) empty() bool {
+	return p.size() == 0
+}
+
+func (p *pool) size() int32 {
+	return p.counter.Load() + p.manualCounter.Load()
+}
+
+func (p *pool) inc(t *Token) {
+	if t.Type == "api" {
+		p.counter.Inc()
+	} else {
+		p.manualCounter.Inc()
+	}
+}
+
+func (p *pool) dec(t *Token) {
+	if t.Type == "api" {
+		p.counter.Dec()
+	} else {
+		p.manualCounter.Dec()
+	}
+}
+
+// push hands t to the first waiter, or adds it to the pool unless it already
+// expired. It reports whether t is now the first token of the pool to
+// expire.
+func (p *pool) push(t *Token) bool {
+	if t.Expired() {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for e := p.waiters.Front(); e != nil; e = e.Next() {
+		if w := e.Value.(*waiter); w.min < t.Remaining() {
+			p.waiters.Remove(e)
+			w.ch <- t
+			return false
+		}
+	}
+	heap.Push(&p.heap, t)
+	p.inc(t)
+	return p.heap[0] == t
+}
+
+// popOrWait removes the token chosen by policy. If no token has enough life
+// left and wait is set it queues a *waiter instead, whose channel receives
+// the next suitable pushed token.
+func (p *pool) popOrWait(policy ConsumePolicy, wait bool) (*Token, *list.Element) {
+	p.demand.Inc()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i := p.heap.pick(policy); i >= 0 {
+		t := heap.Remove(&p.heap, i).(*Token)
+		p.dec(t)
+		return t, nil
+	}
+	if !wait {
+		return nil, nil
+	}
+	return nil, p.waiters.PushBack(&waiter{ch: make(chan *Token, 1), min: policy.MinRemaining})
+}
+
+// cancelWait removes a waiter queued by popOrWait, returning the token it
+// was handed in the meantime if any.
+func (p *pool) cancelWait(e *list.Element) *Token {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.waiters.Remove(e)
+	select {
+	case t := <-e.Value.(*waiter).ch:
+		return t
+	default:
+		return nil
+	}
+}
+
+// tokens returns the unexpired tokens of the pool without removing them.
+func (p *pool) tokens() []*Token {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var tokens []*Token
+	for _, t := range p.heap {
+		if !t.Expired() {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// expire drops the expired tokens. It returns the expiry of the next token,
+// zero if the pool is empty, and the tokens dropped.
+func (p *pool) expire() (time.Time, []*Token) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var expired []*Token
+	for p.heap.Len() > 0 && p.heap[0].Expired() {
+		t := heap.Pop(&p.heap).(*Token)
+		p.dec(t)
+		p.expired.Inc()
+		expired = append(expired, t)
+	}
+	if p.heap.Len() == 0 {
+		return time.Time{}, expired
+	}
+	return p.heap[0].ExpiresAt, expired
+}
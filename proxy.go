@@ -0,0 +1,158 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	d "bitbucket.org/babylonaio/pkg/datastore"
+)
+
+// ProxyType is the protocol spoken by a proxy.
+type ProxyType string
+
+const (
+	ProxyHTTP   ProxyType = "http"
+	ProxyHTTPS  ProxyType = "https"
+	ProxySOCKS4 ProxyType = "socks4"
+	ProxySOCKS5 ProxyType = "socks5"
+)
+
+// Proxy is a proxy the provider solves through, so the token is issued to
+// the same IP that submits it.
+type Proxy struct {
+	Type     ProxyType
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// NewProxy converts a datastore proxy, which carries no type, to a Proxy of
+// type t. It fails if the port of p is not a valid port number.
+func NewProxy(p *d.Proxy, t ProxyType) (*Proxy, error) {
+	port, err := strconv.Atoi(p.Port)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("proxy %s: invalid port %q", p.Host, p.Port)
+	}
+	return &Proxy{Type: t, Host: p.Host, Port: port, Username: p.Username, Password: p.Password}, nil
+}
+
+// NewProxies converts datastore proxies with NewProxy, skipping those that
+// fail to convert.
+func NewProxies(proxies []*d.Proxy, t ProxyType) []*Proxy {
+	converted := make([]*Proxy, 0, len(proxies))
+	for _, p := range proxies {
+		if proxy, err := NewProxy(p, t); err == nil {
+			converted = append(converted, proxy)
+		}
+	}
+	return converted
+}
+
+// proxyType returns the Type of the proxy, defaulting to ProxyHTTP.
+func (p *Proxy) proxyType() ProxyType {
+	if p.Type == "" {
+		return ProxyHTTP
+	}
+	return p.Type
+}
+
+// String formats the proxy as 2captcha expects it, login:password@host:port.
+func (p *Proxy) String() string {
+	addr := net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+	if p.Username == "" {
+		return addr
+	}
+	return p.Username + ":" + p.Password + "@" + addr
+}
+
+// twoCaptchaType returns the proxytype value of 2captcha.
+func (p *Proxy) twoCaptchaType() string {
+	return strings.ToUpper(string(p.proxyType()))
+}
+
+// ProxyMode chooses between proxied and proxyless solves for a client.
+type ProxyMode int
+
+const (
+	// ProxyAuto solves through the proxy of the task or of the client when
+	// there is one, and proxyless otherwise.
+	ProxyAuto ProxyMode = iota
+	// Proxyless never sends a proxy, even if the task has one.
+	Proxyless
+	// ProxyRequired fails solves that have no proxy with ErrNoProxy.
+	ProxyRequired
+)
+
+// proxyRotation holds the proxy mode and pool of a client.
+type proxyRotation struct {
+	mu   sync.RWMutex
+	mode ProxyMode
+	pool *ProxyPool
+}
+
+// SetProxyMode chooses between proxied and proxyless solves.
+func (r *proxyRotation) SetProxyMode(mode ProxyMode) {
+	r.mu.Lock()
+	r.mode = mode
+	r.mu.Unlock()
+}
+
+// SetProxyPool sets the proxies the client solves through. Clients can
+// share a pool.
+func (r *proxyRotation) SetProxyPool(pool *ProxyPool) {
+	r.mu.Lock()
+	r.pool = pool
+	r.mu.Unlock()
+}
+
+// solve runs solve through the proxy of task, or through proxies of the
+// pool. A proxy rejected by the provider is cooled down and the solve moves
+// on to another one, up to the MaxRotations of the pool. When the pool has
+// no proxy available the solve goes proxyless, or fails with ErrNoProxy in
+// ProxyRequired mode.
+func (r *proxyRotation) solve(ctx context.Context, task Task, solve func(context.Context, Task, *Proxy) (*Token, error)) (*Token, error) {
+	r.mu.RLock()
+	mode, pool := r.mode, r.pool
+	r.mu.RUnlock()
+	switch {
+	case mode == Proxyless:
+		return solve(ctx, task, nil)
+	case task.Proxy != nil:
+		return solve(ctx, task, task.Proxy)
+	}
+
+	var err error
+	tried := map[*Proxy]bool{}
+	for i := 0; pool.Len() > 0 && i <= pool.cfg.MaxRotations; i++ {
+		prox := pool.acquire(workerFrom(ctx), tried)
+		if prox == nil {
+			break
+		}
+		tried[prox] = true
+		var token *Token
+		token, err = solve(ctx, task, prox)
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		pool.record(prox, err)
+		if err == nil {
+			return token, nil
+		}
+		if !errors.Is(err, ErrBadProxy) {
+			return nil, err
+		}
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case mode == ProxyRequired:
+		return nil, ErrNoProxy
+	}
+	return solve(ctx, task, nil)
+}
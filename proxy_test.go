@@ -0,0 +1,188 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	d "bitbucket.org/babylonaio/pkg/datastore"
+)
+
+func TestTaskClientProxyFields(t *testing.T) {
+	client := NewAntiCaptchaClient("key")
+	proxy := &Proxy{Type: ProxySOCKS5, Host: "10.0.0.1", Port: 1080, Username: "u", Password: "p"}
+
+	body, err := client.newTask(testTask, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["type"] != "NoCaptchaTask" || body["proxyType"] != "socks5" || body["proxyPort"] != 1080 {
+		t.Errorf("unexpected task %v", body)
+	}
+
+	v3 := testTask
+	v3.Kind = RecaptchaV3
+	body, err = client.newTask(v3, proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["type"] != "RecaptchaV3TaskProxyless" || body["proxyType"] != nil {
+		t.Errorf("proxy sent for a proxyless only kind: %v", body)
+	}
+}
+
+func TestProxyPoolRotation(t *testing.T) {
+	a, b, c := &Proxy{Host: "a", Port: 1}, &Proxy{Host: "b", Port: 2}, &Proxy{Host: "c", Port: 3}
+	cfg := DefaultProxyPoolConfig
+	cfg.MaxRotations = 1
+	pool := NewProxyPoolWithConfig([]*Proxy{a, b, c}, cfg)
+	r := &proxyRotation{pool: pool}
+
+	var used []*Proxy
+	solve := func(ctx context.Context, task Task, p *Proxy) (*Token, error) {
+		used = append(used, p)
+		if p == a || p == b {
+			return nil, newProviderError("fake", "ERROR_PROXY_CONNECT_REFUSED")
+		}
+		return &Token{Token: "ok"}, nil
+	}
+
+	if _, err := r.solve(context.Background(), testTask, solve); !errors.Is(err, ErrBadProxy) {
+		t.Fatalf("got %v, want ErrBadProxy after a single rotation", err)
+	}
+	if len(used) != 2 || used[0] != a || used[1] != b {
+		t.Fatalf("used %v, want a then b", used)
+	}
+	if _, err := r.solve(context.Background(), testTask, solve); err != nil {
+		t.Fatal(err)
+	}
+	if used[2] != c {
+		t.Errorf("used %v while a and b cool down", used[2])
+	}
+	for _, s := range pool.Stats() {
+		if s.Proxy == c && s.Successes != 1 || s.Proxy != c && (s.Failures != 1 || s.CoolingUntil.IsZero()) {
+			t.Errorf("unexpected stats %+v", s)
+		}
+	}
+}
+
+func TestProxyPoolSticky(t *testing.T) {
+	a, b := &Proxy{Host: "a", Port: 1}, &Proxy{Host: "b", Port: 2}
+	cfg := DefaultProxyPoolConfig
+	cfg.Selection = Sticky
+	pool := NewProxyPoolWithConfig([]*Proxy{a, b}, cfg)
+
+	first := pool.acquire("w1", nil)
+	second := pool.acquire("w2", nil)
+	if first == second {
+		t.Fatal("two workers got the same proxy")
+	}
+	if p := pool.acquire("w1", nil); p != first {
+		t.Errorf("worker moved from %v to %v", first, p)
+	}
+	pool.record(first, newProviderError("fake", "ERROR_BAD_PROXY"))
+	if p := pool.acquire("w1", nil); p != second {
+		t.Errorf("got %v, want the proxy that is not cooling down", p)
+	}
+}
+
+func TestProxyModes(t *testing.T) {
+	a := &Proxy{Host: "a", Port: 1}
+	var got *Proxy
+	solve := func(ctx context.Context, task Task, p *Proxy) (*Token, error) {
+		got = p
+		return &Token{}, nil
+	}
+
+	r := &proxyRotation{pool: NewProxyPool([]*Proxy{a}), mode: Proxyless}
+	r.solve(context.Background(), Task{Proxy: a}, solve)
+	if got != nil {
+		t.Errorf("proxyless mode used %v", got)
+	}
+	r = &proxyRotation{mode: ProxyRequired}
+	if _, err := r.solve(context.Background(), testTask, solve); err != ErrNoProxy {
+		t.Errorf("got %v, want ErrNoProxy", err)
+	}
+	if p := a.String(); p != "a:1" {
+		t.Errorf("got %q", p)
+	}
+}
+
+// workerCaptcha records the workers its solves were tagged with. Solves
+// return once release is closed.
+type workerCaptcha struct {
+	mu      sync.Mutex
+	workers map[string]bool
+	release chan struct{}
+}
+
+func (c *workerCaptcha) Name() string { return "workers" }
+
+func (c *workerCaptcha) Solve(ctx context.Context, task Task) (*Token, error) {
+	c.mu.Lock()
+	c.workers[workerFrom(ctx)] = true
+	c.mu.Unlock()
+	<-c.release
+	return newAPIToken("workers", "1", "token"), nil
+}
+
+func TestHarvestTagsWorkers(t *testing.T) {
+	sel := Sticky
+	c, err := NewCaptchaBank(Config{ProxyPool: &ProxyPoolConfig{Selection: sel}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.proxyPool.Selection != sel {
+		t.Errorf("proxy pool config %+v not kept", c.proxyPool)
+	}
+	client := &workerCaptcha{workers: map[string]bool{}, release: make(chan struct{})}
+	c.AddCaptchaClient(client)
+	p := c.getPool(testTask, true)
+	p.setConfig(PoolConfig{MaxSize: 2, Workers: 2})
+
+	c.startSolve(context.Background(), p)
+	c.startSolve(context.Background(), p)
+	close(client.release)
+	c.workers.Wait()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, worker := range []string{testTask.Key() + "#0", testTask.Key() + "#1"} {
+		if !client.workers[worker] {
+			t.Errorf("no solve tagged %q in %v", worker, client.workers)
+		}
+	}
+}
+
+func TestNewProxiesSkipsBadPorts(t *testing.T) {
+	proxies := NewProxies([]*d.Proxy{
+		{Host: "a", Port: "8080"},
+		{Host: "b", Port: "http"},
+		{Host: "c", Port: ""},
+	}, ProxyHTTP)
+	if len(proxies) != 1 || proxies[0].Host != "a" || proxies[0].Port != 8080 {
+		t.Errorf("got %v, want only a:8080", proxies)
+	}
+}
+
+func TestConfigProxyModes(t *testing.T) {
+	c, err := NewCaptchaBank(Config{
+		Keys:       Keys{TwoCaptcha: "two", AntiCaptcha: "anti"},
+		ProxyModes: ProxyModes{TwoCaptcha: Proxyless, AntiCaptcha: ProxyRequired},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := map[string]ProxyMode{}
+	for _, client := range c.clientList() {
+		switch captcha := client.Captcha.(type) {
+		case *TwoCaptcha:
+			modes["2captcha"] = captcha.mode
+		case *TaskCaptcha:
+			modes["anticaptcha"] = captcha.mode
+		}
+	}
+	if modes["2captcha"] != Proxyless || modes["anticaptcha"] != ProxyRequired {
+		t.Errorf("got modes %v", modes)
+	}
+}
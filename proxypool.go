@@ -0,0 +1,166 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ProxySelection decides which proxy of a ProxyPool a solve goes through.
+type ProxySelection int
+
+const (
+	// LeastRecentlyUsed picks the available proxy unused for the longest
+	// time, spreading concurrent solves over the pool.
+	LeastRecentlyUsed ProxySelection = iota
+	// Sticky keeps giving a worker, as set by WithWorker, the proxy it got
+	// first for as long as the proxy works. Solves without a worker fall
+	// back to LeastRecentlyUsed.
+	Sticky
+)
+
+// ProxyStats is the health the pool recorded for one proxy.
+type ProxyStats struct {
+	Proxy     *Proxy
+	Successes int
+	Failures  int
+	LastUsed  time.Time
+	// CoolingUntil is set after the provider rejected the proxy. The proxy
+	// is skipped until then.
+	CoolingUntil time.Time
+}
+
+// ProxyPoolConfig holds the settings of a ProxyPool.
+type ProxyPoolConfig struct {
+	Selection ProxySelection
+	// Cooldown is how long a proxy is skipped after an ErrBadProxy.
+	Cooldown time.Duration
+	// MaxRotations is how many other proxies a solve tries after its proxy
+	// was rejected.
+	MaxRotations int
+}
+
+// DefaultProxyPoolConfig picks the least recently used proxy, cools
+// rejected proxies down for five minutes and rotates up to twice per solve.
+var DefaultProxyPoolConfig = ProxyPoolConfig{
+	Selection:    LeastRecentlyUsed,
+	Cooldown:     5 * time.Minute,
+	MaxRotations: 2,
+}
+
+// ProxyPool hands out proxies to solves, keeping track of how each of them
+// does. A pool can be shared by several clients. Its settings are fixed
+// when it is created.
+type ProxyPool struct {
+	cfg ProxyPoolConfig
+
+	mu      sync.Mutex
+	proxies []*ProxyStats
+	sticky  map[string]*ProxyStats
+}
+
+// NewProxyPool creates a pool of proxies with DefaultProxyPoolConfig.
+func NewProxyPool(proxies []*Proxy) *ProxyPool {
+	return NewProxyPoolWithConfig(proxies, DefaultProxyPoolConfig)
+}
+
+// NewProxyPoolWithConfig creates a pool of proxies with the settings of cfg.
+func NewProxyPoolWithConfig(proxies []*Proxy, cfg ProxyPoolConfig) *ProxyPool {
+	p := &ProxyPool{
+		cfg:    cfg,
+		sticky: map[string]*ProxyStats{},
+	}
+	for _, proxy := range proxies {
+		p.proxies = append(p.proxies, &ProxyStats{Proxy: proxy})
+	}
+	return p
+}
+
+// Len returns the number of proxies in the pool.
+func (p *ProxyPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.proxies)
+}
+
+// Stats returns the health of every proxy of the pool.
+func (p *ProxyPool) Stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ProxyStats, 0, len(p.proxies))
+	for _, s := range p.proxies {
+		stats = append(stats, *s)
+	}
+	return stats
+}
+
+// acquire returns the proxy for a solve of worker, skipping cooling proxies
+// and those in exclude, or nil if none is available.
+func (p *ProxyPool) acquire(worker string, exclude map[*Proxy]bool) *Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	usable := func(s *ProxyStats) bool {
+		return s != nil && !exclude[s.Proxy] && !now.Before(s.CoolingUntil)
+	}
+	sticky := p.cfg.Selection == Sticky && worker != ""
+	if s := p.sticky[worker]; sticky && usable(s) {
+		s.LastUsed = now
+		return s.Proxy
+	}
+	var best *ProxyStats
+	for _, s := range p.proxies {
+		if usable(s) && (best == nil || s.LastUsed.Before(best.LastUsed)) {
+			best = s
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	best.LastUsed = now
+	if sticky {
+		p.sticky[worker] = best
+	}
+	return best.Proxy
+}
+
+// record updates the stats of proxy with the result of a solve.
+func (p *ProxyPool) record(proxy *Proxy, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.proxies {
+		if s.Proxy != proxy {
+			continue
+		}
+		switch {
+		case err == nil:
+			s.Successes++
+		case errors.Is(err, ErrBadProxy):
+			s.Failures++
+			s.CoolingUntil = time.Now().Add(p.cfg.Cooldown)
+			for worker, sticky := range p.sticky {
+				if sticky == s {
+					delete(p.sticky, worker)
+				}
+			}
+		default:
+			s.Failures++
+		}
+		return
+	}
+}
+
+type workerKey struct{}
+
+// WithWorker tags ctx with the worker a solve runs for, so that a Sticky
+// ProxyPool keeps using the same proxy for it.
+func WithWorker(ctx context.Context, worker string) context.Context {
+	return context.WithValue(ctx, workerKey{}, worker)
+}
+
+func workerFrom(ctx context.Context) string {
+	worker, _ := ctx.Value(workerKey{}).(string)
+	return worker
+}
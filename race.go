@@ -0,0 +1,102 @@
+package solver
+
+import (
+	"context"
+	"time"
+)
+
+// RaceConfig makes GetTokenWithAPI fan a solve out to several clients and
+// return the first token.
+//
+// None of the supported providers can cancel a task once it was created, so
+// the losing solves are stopped through their context and any token they
+// still return is kept in the pool of the task instead of being thrown away.
+type RaceConfig struct {
+	// Providers is the number of clients raced. Values below 2 disable
+	// racing.
+	Providers int
+	// HedgeDelay is how long to wait for a result before starting the next
+	// client. Zero starts all of them at once.
+	HedgeDelay time.Duration
+}
+
+// SetRace configures racing for GetTokenWithAPI.
+func (c *CaptchaBank) SetRace(cfg RaceConfig) {
+	c.race = cfg
+}
+
+type raceResult struct {
+	token *Token
+	err   error
+}
+
+// raceSolve starts the solve on up to c.race.Providers clients, hedging by
+// c.race.HedgeDelay, and returns the first token. Failed solves are replaced
+// by another client the same way solve retries them.
+func (c *CaptchaBank) raceSolve(ctx context.Context, task Task) (*Token, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan raceResult, len(c.clientList()))
+	tried := map[*bankClient]bool{}
+	started, pending := 0, 0
+	launch := func() bool {
+		client := c.pick(tried)
+		if client == nil {
+			return false
+		}
+		tried[client] = true
+		started++
+		pending++
+		go func() {
+			t, err := c.solveWith(ctx, client, task)
+			results <- raceResult{token: t, err: err}
+		}()
+		return true
+	}
+
+	err := ErrNoClients
+	if !launch() {
+		return nil, err
+	}
+	for c.race.HedgeDelay <= 0 && started < c.race.Providers && launch() {
+	}
+	for pending > 0 {
+		var hedge <-chan time.Time
+		if started < c.race.Providers {
+			hedge = time.After(c.race.HedgeDelay)
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go c.salvage(task, results, pending)
+				return r.token, nil
+			}
+			err = r.err
+			if retryable(r.err) || fatal(r.err) {
+				launch()
+			}
+		case <-hedge:
+			launch()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// salvage waits for the losing solves of a race and keeps the tokens they
+// returned despite being cancelled.
+func (c *CaptchaBank) salvage(task Task, results <-chan raceResult, pending int) {
+	p := c.getPool(task, true)
+	for ; pending > 0; pending-- {
+		r := <-results
+		if r.err != nil || p.full() {
+			continue
+		}
+		r.token.Key = task.Key()
+		c.add(p, r.token)
+	}
+}
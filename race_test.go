@@ -0,0 +1,62 @@
+package solver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"bitbucket.org/babylonaio/pkg/solver/solvertest"
+)
+
+// newRaceBank returns a bank racing the clients of first, always tried
+// first, and second.
+func newRaceBank(t *testing.T, first, second *solvertest.Server, hedge time.Duration) *CaptchaBank {
+	c, err := NewCaptchaBank(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetSelector(&RoundRobinSelector{})
+	c.AddCaptchaClient(newTestClient(first, "first"))
+	c.AddCaptchaClient(newTestClient(second, "second"))
+	c.SetRace(RaceConfig{Providers: 2, HedgeDelay: hedge})
+	return c
+}
+
+func TestRaceHedges(t *testing.T) {
+	slow := solvertest.NewServer(solvertest.Config{Latency: time.Second})
+	defer slow.Close()
+	fast := solvertest.NewServer(solvertest.Config{Latency: 10 * time.Millisecond})
+	defer fast.Close()
+	c := newRaceBank(t, slow, fast, 30*time.Millisecond)
+
+	start := time.Now()
+	token, err := c.GetTokenWithAPI(context.Background(), testTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Provider != "second" {
+		t.Errorf("won by %q, want the hedged fast client", token.Provider)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("race took %v", d)
+	}
+	if slow.Created() != 1 || fast.Created() != 1 {
+		t.Errorf("created %d slow and %d fast tasks, want one each", slow.Created(), fast.Created())
+	}
+}
+
+func TestRaceSkipsHedgeWhenFirstWins(t *testing.T) {
+	first := solvertest.NewServer(solvertest.Config{Latency: 10 * time.Millisecond})
+	defer first.Close()
+	second := solvertest.NewServer(solvertest.Config{})
+	defer second.Close()
+	c := newRaceBank(t, first, second, 500*time.Millisecond)
+
+	token, err := c.GetTokenWithAPI(context.Background(), testTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Provider != "first" || second.Created() != 0 {
+		t.Errorf("won by %q with %d hedged tasks, want the first client alone", token.Provider, second.Created())
+	}
+}
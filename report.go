@@ -0,0 +1,33 @@
+package solver
+
+import (
+	"context"
+	"time"
+)
+
+// Reporter is implemented by clients whose provider takes feedback on the
+// tokens it solved.
+type Reporter interface {
+	Report(ctx context.Context, t *Token, ok bool) error
+}
+
+// Report tells the provider that solved t whether the token was accepted.
+// Manual tokens are ignored.
+func (c *CaptchaBank) Report(t *Token, ok bool) error {
+	if t == nil || t.Provider == "" || t.TaskID == "" {
+		return nil
+	}
+	for _, client := range c.clientList() {
+		if client.Name() != t.Provider {
+			continue
+		}
+		reporter, isReporter := client.Captcha.(Reporter)
+		if !isReporter {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return reporter.Report(ctx, t, ok)
+	}
+	return ErrNoClients
+}
@@ -0,0 +1,178 @@
+package solver
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	atom "github.com/uber-go/atomic"
+)
+
+// ewmaAlpha is the weight of the latest solve in the latency and success
+// rate averages.
+const ewmaAlpha = 0.2
+
+// ClientStats is the health information the bank keeps about a client.
+type ClientStats struct {
+	// Weight is the share of traffic configured for the client.
+	Weight float64
+	// Latency is the moving average of successful solve durations, zero
+	// until the client solved once.
+	Latency time.Duration
+	// SuccessRate is the moving average of successful solves, from 0 to 1.
+	SuccessRate float64
+}
+
+// Selector picks the client used for the next solve. Select is given the
+// stats of the clients that are currently usable and returns the index of
+// the chosen one.
+type Selector interface {
+	Select(clients []ClientStats) int
+}
+
+// RoundRobinSelector cycles through the clients in order.
+type RoundRobinSelector struct {
+	next atom.Int32
+}
+
+func (s *RoundRobinSelector) Select(clients []ClientStats) int {
+	return int(uint32(s.next.Inc()-1) % uint32(len(clients)))
+}
+
+// WeightedSelector picks a client at random in proportion to its Weight.
+type WeightedSelector struct{}
+
+func (WeightedSelector) Select(clients []ClientStats) int {
+	total := 0.0
+	for _, c := range clients {
+		total += c.Weight
+	}
+	if total <= 0 {
+		return rand.Intn(len(clients))
+	}
+	r := rand.Float64() * total
+	for i, c := range clients {
+		r -= c.Weight
+		if r < 0 {
+			return i
+		}
+	}
+	return len(clients) - 1
+}
+
+// LeastLatencySelector picks the client with the lowest average solve
+// latency. Clients that have not solved anything yet are tried first.
+type LeastLatencySelector struct{}
+
+func (LeastLatencySelector) Select(clients []ClientStats) int {
+	best := 0
+	for i, c := range clients {
+		if c.Latency == 0 {
+			return i
+		}
+		if c.Latency < clients[best].Latency {
+			best = i
+		}
+	}
+	return best
+}
+
+// SuccessRateSelector picks the client with the highest success rate.
+type SuccessRateSelector struct{}
+
+func (SuccessRateSelector) Select(clients []ClientStats) int {
+	best := 0
+	for i, c := range clients {
+		if c.SuccessRate > clients[best].SuccessRate {
+			best = i
+		}
+	}
+	return best
+}
+
+// bankClient wraps a Captcha with the state the bank keeps about it.
+type bankClient struct {
+	Captcha
+	disabled atom.Bool
+	// suspended is set while the account balance is below the bank
+	// threshold, and cleared again once it is topped up.
+	suspended atom.Bool
+	balance   atom.Float64
+	weight    float64
+
+	mu          sync.Mutex
+	latency     time.Duration
+	successRate float64
+	// failures counts consecutive failed solves. Once it reaches the
+	// breaker threshold the client is skipped until openUntil, after which a
+	// single probe is let through.
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newBankClient(c Captcha, weight float64) *bankClient {
+	return &bankClient{Captcha: c, weight: weight, successRate: 1}
+}
+
+func (b *bankClient) stats() ClientStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return ClientStats{Weight: b.weight, Latency: b.latency, SuccessRate: b.successRate}
+}
+
+// available reports whether the circuit breaker of the client is closed or
+// ready for a half-open probe.
+func (b *bankClient) available(threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < threshold {
+		return true
+	}
+	return !b.probing && !time.Now().Before(b.openUntil)
+}
+
+// acquire is called once the client was selected. It marks the half-open
+// probe as in flight so no other solve goes through until it finished.
+func (b *bankClient) acquire(threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// release ends a probe without recording a result, used when the solve was
+// cancelled by the caller.
+func (b *bankClient) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// record updates the stats and circuit breaker with the result of a solve.
+func (b *bankClient) record(d time.Duration, err error, threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		if b.latency == 0 {
+			b.latency = d
+		} else {
+			b.latency = time.Duration(ewmaAlpha*float64(d) + (1-ewmaAlpha)*float64(b.latency))
+		}
+		b.successRate = ewmaAlpha + (1-ewmaAlpha)*b.successRate
+		b.failures = 0
+		return
+	}
+	b.successRate = (1 - ewmaAlpha) * b.successRate
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
@@ -0,0 +1,97 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bitbucket.org/babylonaio/pkg/solver/solvertest"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{})
+	defer s.Close()
+	c, err := NewCaptchaBank(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AddCaptchaClient(newTestClient(s, "fake"))
+	c.SetCircuitBreaker(2, 50*time.Millisecond)
+	s.FailSubmit("ERROR_NO_SLOT_AVAILABLE", "ERROR_NO_SLOT_AVAILABLE", "ERROR_NO_SLOT_AVAILABLE")
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetTokenWithAPI(context.Background(), testTask); !errors.Is(err, ErrNoSlotAvailable) {
+			t.Fatalf("solve %d: got %v, want ErrNoSlotAvailable", i, err)
+		}
+	}
+	if _, err := c.GetTokenWithAPI(context.Background(), testTask); !errors.Is(err, ErrNoClients) {
+		t.Fatalf("open breaker: got %v, want ErrNoClients", err)
+	}
+
+	// The failed probe opens the breaker again.
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.GetTokenWithAPI(context.Background(), testTask); !errors.Is(err, ErrNoSlotAvailable) {
+		t.Fatalf("probe: got %v, want ErrNoSlotAvailable", err)
+	}
+	if _, err := c.GetTokenWithAPI(context.Background(), testTask); !errors.Is(err, ErrNoClients) {
+		t.Fatalf("after failed probe: got %v, want ErrNoClients", err)
+	}
+
+	// A successful probe closes it.
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetTokenWithAPI(context.Background(), testTask); err != nil {
+			t.Fatalf("closed breaker, solve %d: %v", i, err)
+		}
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	b := newBankClient(nil, 1)
+	b.record(0, ErrProviderDown, 1, time.Millisecond)
+	if b.available(1) {
+		t.Fatal("open breaker available")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if !b.acquire(1) {
+		t.Fatal("no probe after the cooldown")
+	}
+	if b.available(1) || b.acquire(1) {
+		t.Error("second probe let through")
+	}
+	b.release()
+	if !b.acquire(1) {
+		t.Error("no probe after a cancelled one")
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	clients := []ClientStats{
+		{Weight: 0, Latency: 30 * time.Millisecond, SuccessRate: 0.5},
+		{Weight: 1, Latency: 10 * time.Millisecond, SuccessRate: 0.9},
+		{Weight: 0, Latency: 20 * time.Millisecond, SuccessRate: 0.7},
+	}
+	rr := &RoundRobinSelector{}
+	for i, want := range []int{0, 1, 2, 0} {
+		if got := rr.Select(clients); got != want {
+			t.Errorf("round robin pick %d = %d, want %d", i, got, want)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if got := (WeightedSelector{}).Select(clients); got != 1 {
+			t.Fatalf("weighted picked %d, want the only weighted client", got)
+		}
+	}
+	if got := (LeastLatencySelector{}).Select(clients); got != 1 {
+		t.Errorf("least latency picked %d, want 1", got)
+	}
+	untried := append([]ClientStats{}, clients...)
+	untried[2].Latency = 0
+	if got := (LeastLatencySelector{}).Select(untried); got != 2 {
+		t.Errorf("least latency picked %d, want the untried client", got)
+	}
+	if got := (SuccessRateSelector{}).Select(clients); got != 1 {
+		t.Errorf("success rate picked %d, want 1", got)
+	}
+}
@@ -0,0 +1,274 @@
+// Package solvertest provides a fake captcha provider for tests. A Server
+// speaks both the 2captcha in.php/res.php protocol and the createTask/
+// getTaskResult protocol of anti-captcha and capmonster, so solver clients
+// can be pointed at it instead of a paid service.
+package solvertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config scripts the behaviour of a Server.
+type Config struct {
+	// Key is the API key the server accepts. Empty accepts any key.
+	Key string
+	// Latency is how long a task stays unsolved after it was submitted.
+	// Polls before that answer CAPCHA_NOT_READY, or status processing.
+	Latency time.Duration
+	// Balance is the account balance reported by the server.
+	Balance float64
+	// Token returns the solution of the task with the given id. The default
+	// answers "token-<id>".
+	Token func(id int) string
+}
+
+// Server is a fake captcha provider served over HTTP. The 2captcha client
+// takes its URL as base url, as do the createTask clients.
+type Server struct {
+	*httptest.Server
+	cfg Config
+
+	mu      sync.Mutex
+	tasks   map[int]*task
+	nextID  int
+	errors  []string
+	results []string
+	reports map[int]bool
+}
+
+type task struct {
+	readyAt time.Time
+	err     string
+}
+
+// NewServer starts a Server. Close it when done.
+func NewServer(cfg Config) *Server {
+	if cfg.Token == nil {
+		cfg.Token = func(id int) string { return "token-" + strconv.Itoa(id) }
+	}
+	s := &Server{cfg: cfg, tasks: map[int]*task{}, reports: map[int]bool{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/in.php", s.in)
+	mux.HandleFunc("/res.php", s.res)
+	mux.HandleFunc("/createTask", s.createTask)
+	mux.HandleFunc("/getTaskResult", s.getTaskResult)
+	mux.HandleFunc("/getBalance", s.getBalance)
+	mux.HandleFunc("/reportIncorrectRecaptcha", s.report(false))
+	mux.HandleFunc("/reportCorrectRecaptcha", s.report(true))
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// FailSubmit makes the next submissions fail with codes, one per
+// submission, such as ERROR_ZERO_BALANCE or ERROR_NO_SLOT_AVAILABLE. An
+// empty code lets the submission through.
+func (s *Server) FailSubmit(codes ...string) {
+	s.mu.Lock()
+	s.errors = append(s.errors, codes...)
+	s.mu.Unlock()
+}
+
+// FailResult makes the next submitted tasks fail with codes, such as
+// ERROR_CAPTCHA_UNSOLVABLE, once their latency elapsed.
+func (s *Server) FailResult(codes ...string) {
+	s.mu.Lock()
+	s.results = append(s.results, codes...)
+	s.mu.Unlock()
+}
+
+// SetLatency changes the latency of the tasks submitted from now on.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.cfg.Latency = d
+	s.mu.Unlock()
+}
+
+// SetBalance changes the reported balance.
+func (s *Server) SetBalance(b float64) {
+	s.mu.Lock()
+	s.cfg.Balance = b
+	s.mu.Unlock()
+}
+
+// Created returns the number of tasks submitted successfully.
+func (s *Server) Created() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks)
+}
+
+// Report returns the report received for the task id, and whether there was
+// one.
+func (s *Server) Report(id int) (ok, reported bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok, reported = s.reports[id]
+	return ok, reported
+}
+
+// submit registers a task, or returns the scripted error code.
+func (s *Server) submit(key string) (int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.Key != "" && key != s.cfg.Key {
+		return 0, "ERROR_KEY_DOES_NOT_EXIST"
+	}
+	if len(s.errors) > 0 {
+		code := s.errors[0]
+		s.errors = s.errors[1:]
+		if code != "" {
+			return 0, code
+		}
+	}
+	t := &task{readyAt: time.Now().Add(s.cfg.Latency)}
+	if len(s.results) > 0 {
+		t.err = s.results[0]
+		s.results = s.results[1:]
+	}
+	s.nextID++
+	s.tasks[s.nextID] = t
+	return s.nextID, ""
+}
+
+// result returns the solution of the task id, whether it is ready, and its
+// error code if it failed.
+func (s *Server) result(key string, id int) (string, bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.Key != "" && key != s.cfg.Key {
+		return "", false, "ERROR_KEY_DOES_NOT_EXIST"
+	}
+	t, ok := s.tasks[id]
+	if !ok {
+		return "", false, "ERROR_NO_SUCH_CAPCHA_ID"
+	}
+	if time.Now().Before(t.readyAt) {
+		return "", false, ""
+	}
+	if t.err != "" {
+		return "", false, t.err
+	}
+	return s.cfg.Token(id), true, ""
+}
+
+func (s *Server) balance() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.Balance
+}
+
+// in serves the 2captcha submission endpoint.
+func (s *Server) in(w http.ResponseWriter, r *http.Request) {
+	id, code := s.submit(r.FormValue("key"))
+	if code != "" {
+		fmt.Fprint(w, code)
+		return
+	}
+	fmt.Fprintf(w, "OK|%d", id)
+}
+
+// res serves the 2captcha result, balance and report endpoint.
+func (s *Server) res(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	switch r.FormValue("action") {
+	case "get":
+		token, ready, code := s.result(r.FormValue("key"), id)
+		switch {
+		case code != "":
+			fmt.Fprint(w, code)
+		case !ready:
+			fmt.Fprint(w, "CAPCHA_NOT_READY")
+		default:
+			fmt.Fprint(w, "OK|"+token)
+		}
+	case "getbalance":
+		fmt.Fprint(w, strconv.FormatFloat(s.balance(), 'f', -1, 64))
+	case "reportgood", "reportbad":
+		s.mu.Lock()
+		s.reports[id] = r.FormValue("action") == "reportgood"
+		s.mu.Unlock()
+		fmt.Fprint(w, "OK_REPORT_RECORDED")
+	default:
+		fmt.Fprint(w, "ERROR_WRONG_ACTION")
+	}
+}
+
+type taskRequest struct {
+	ClientKey string          `json:"clientKey"`
+	TaskID    json.RawMessage `json:"taskId"`
+}
+
+func (r taskRequest) id() int {
+	id, _ := strconv.Atoi(string(r.TaskID))
+	return id
+}
+
+func decodeTask(r *http.Request) taskRequest {
+	var req taskRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	return req
+}
+
+func writeJSON(w http.ResponseWriter, v map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeTaskError(w http.ResponseWriter, code string) {
+	writeJSON(w, map[string]interface{}{"errorId": 1, "errorCode": code})
+}
+
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	req := decodeTask(r)
+	id, code := s.submit(req.ClientKey)
+	if code != "" {
+		writeTaskError(w, code)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"errorId": 0, "taskId": id})
+}
+
+func (s *Server) getTaskResult(w http.ResponseWriter, r *http.Request) {
+	req := decodeTask(r)
+	token, ready, code := s.result(req.ClientKey, req.id())
+	switch {
+	case code != "":
+		writeTaskError(w, code)
+	case !ready:
+		writeJSON(w, map[string]interface{}{"errorId": 0, "status": "processing"})
+	default:
+		writeJSON(w, map[string]interface{}{
+			"errorId": 0,
+			"status":  "ready",
+			"solution": map[string]interface{}{
+				"gRecaptchaResponse": token,
+				"text":               token,
+			},
+		})
+	}
+}
+
+func (s *Server) getBalance(w http.ResponseWriter, r *http.Request) {
+	req := decodeTask(r)
+	if s.cfg.Key != "" && req.ClientKey != s.cfg.Key {
+		writeTaskError(w, "ERROR_KEY_DOES_NOT_EXIST")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"errorId": 0, "balance": s.balance()})
+}
+
+func (s *Server) report(ok bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := decodeTask(r)
+		s.mu.Lock()
+		s.reports[req.id()] = ok
+		s.mu.Unlock()
+		writeJSON(w, map[string]interface{}{"errorId": 0, "status": "success"})
+	}
+}
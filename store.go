@@ -0,0 +1,85 @@
+package solver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// PoolSnapshot is the persisted state of a token pool.
+type PoolSnapshot struct {
+	Task   Task
+	Config PoolConfig
+	Tokens []*Token
+}
+
+// Store persists the unexpired tokens of a bank across restarts.
+type Store interface {
+	Save(pools []PoolSnapshot) error
+	Load() ([]PoolSnapshot, error)
+}
+
+// FileStore is a Store keeping the snapshot as JSON in a local file.
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Save(pools []PoolSnapshot) error {
+	b, err := json.Marshal(pools)
+	if err != nil {
+		return err
+	}
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
+
+func (s *FileStore) Load() ([]PoolSnapshot, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pools []PoolSnapshot
+	if err := json.Unmarshal(b, &pools); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// Save snapshots the unexpired tokens of every pool to the store of the
+// bank. It is called by Stop and should be called on shutdown.
+func (c *CaptchaBank) Save() error {
+	if c.store == nil {
+		return nil
+	}
+	var pools []PoolSnapshot
+	for _, p := range c.poolList() {
+		pools = append(pools, PoolSnapshot{Task: p.task, Config: p.config(), Tokens: p.tokens()})
+	}
+	return c.store.Save(pools)
+}
+
+// restore loads the pools saved in the store of the bank, discarding the
+// tokens that expired in the meantime.
+func (c *CaptchaBank) restore() error {
+	if c.store == nil {
+		return nil
+	}
+	pools, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+	for _, s := range pools {
+		c.AddPool(s.Task, s.Config)
+		p := c.getPool(s.Task, true)
+		for _, t := range s.Tokens {
+			p.push(t)
+		}
+	}
+	return nil
+}
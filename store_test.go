@@ -0,0 +1,66 @@
+package solver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captchabank")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := &FileStore{Path: filepath.Join(dir, "bank.json")}
+
+	c, err := NewCaptchaBank(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := PoolConfig{MinSize: 1, MaxSize: 4, Workers: 2, Expiry: time.Minute}
+	c.AddPool(testTask, cfg)
+	now := time.Now()
+	for _, tok := range []*Token{
+		{Token: "fresh", Type: "api", Provider: "fake", Created: now, ExpiresAt: now.Add(time.Hour)},
+		{Token: "stale", Type: "manual", Created: now, ExpiresAt: now.Add(30 * time.Millisecond)},
+	} {
+		tok.Key = testTask.Key()
+		if err := c.Push(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	restored, err := NewCaptchaBank(Config{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := restored.getPool(testTask, false)
+	if p == nil {
+		t.Fatal("pool not restored")
+	}
+	if got := p.config(); got != cfg {
+		t.Errorf("restored config %+v, want %+v", got, cfg)
+	}
+	tokens := p.tokens()
+	if len(tokens) != 1 || tokens[0].Token != "fresh" || tokens[0].Provider != "fake" {
+		t.Errorf("restored tokens %+v, want only the fresh one", tokens)
+	}
+	if n := p.manualCounter.Load(); n != 0 {
+		t.Errorf("manual counter %d after dropping the stale token", n)
+	}
+}
+
+func TestFileStoreMissingFile(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(os.TempDir(), "captchabank-missing.json")}
+	pools, err := store.Load()
+	if err != nil || pools != nil {
+		t.Errorf("got %v, %v, want nothing", pools, err)
+	}
+}
@@ -0,0 +1,73 @@
+package solver
+
+// CaptchaKind identifies the type of captcha a Task asks for.
+type CaptchaKind string
+
+const (
+	RecaptchaV2          CaptchaKind = "recaptcha_v2"
+	RecaptchaV2Invisible CaptchaKind = "recaptcha_v2_invisible"
+	// RecaptchaV3 uses the Action and MinScore of the task.
+	RecaptchaV3 CaptchaKind = "recaptcha_v3"
+	// RecaptchaEnterprise uses the EnterprisePayload of the task.
+	RecaptchaEnterprise CaptchaKind = "recaptcha_enterprise"
+	HCaptcha            CaptchaKind = "hcaptcha"
+)
+
+// Task describes the captcha to solve. Solvers use the PageURL and SiteKey of
+// the task instead of a fixed page, and a non-nil Proxy takes precedence over
+// the proxy group the solver was created with.
+type Task struct {
+	PageURL   string
+	SiteKey   string
+	Kind      CaptchaKind
+	UserAgent string
+	Proxy     *Proxy
+	// Action and MinScore are the page action and minimum score of a
+	// reCAPTCHA v3.
+	Action   string
+	MinScore float64
+	// EnterprisePayload holds the extra parameters of a reCAPTCHA
+	// Enterprise, such as the "s" value.
+	EnterprisePayload map[string]string
+	// Params holds extra provider parameters sent as-is with the task.
+	Params map[string]string
+}
+
+// DefaultTask is the DataDome reCAPTCHA the bank harvests when no other task
+// has been configured.
+var DefaultTask = Task{
+	PageURL: "https://geo.captcha-delivery.com",
+	SiteKey: "6LccSjEUAAAAANCPhaM2c-WiRxCZ5CzsjR_vd8uX",
+	Kind:    RecaptchaV2,
+}
+
+// Key identifies the token pool of the task. Tokens are interchangeable
+// between tasks that share the page URL, site key and captcha kind.
+func (t Task) Key() string {
+	key := t.PageURL + "|" + t.SiteKey + "|" + string(t.kind())
+	if t.Action != "" {
+		key += "|" + t.Action
+	}
+	return key
+}
+
+// kind returns the Kind of the task, defaulting to RecaptchaV2.
+func (t Task) kind() CaptchaKind {
+	if t.Kind == "" {
+		return RecaptchaV2
+	}
+	return t.Kind
+}
+
+// extra returns the user agent and Params of t in the shape expected by the
+// createTask style APIs.
+func (t Task) extra() map[string]interface{} {
+	m := map[string]interface{}{}
+	if t.UserAgent != "" {
+		m["userAgent"] = t.UserAgent
+	}
+	for k, v := range t.Params {
+		m[k] = v
+	}
+	return m
+}
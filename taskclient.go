@@ -0,0 +1,283 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TaskProvider describes a service speaking the createTask/getTaskResult
+// protocol introduced by anti-captcha. Adding a compatible service only
+// takes a new TaskProvider.
+type TaskProvider struct {
+	Name    string
+	BaseURL string
+	// TaskTypes maps the supported captcha kinds to the provider task types.
+	TaskTypes map[CaptchaKind]TaskType
+	// ImageTask is the task type of image captchas, empty if unsupported.
+	ImageTask string
+	// ReportIncorrectMethod and ReportCorrectMethod are the API methods used
+	// to report tokens. Empty if the provider does not support them.
+	ReportIncorrectMethod string
+	ReportCorrectMethod   string
+	// Poll schedules the getTaskResult calls of a task.
+	Poll PollPolicy
+}
+
+// TaskType holds the task type names of a captcha kind with and without a
+// proxy. An empty Proxy means the kind can only be solved proxyless.
+type TaskType struct {
+	Proxy     string
+	Proxyless string
+}
+
+// TaskClient is a client for the createTask style APIs described by a
+// TaskProvider.
+type TaskClient struct {
+	Provider  TaskProvider
+	ClientKey string
+	// BaseURL is the url the API methods are relative to.
+	BaseURL string
+	Doer    Doer
+}
+
+// NewTaskClient creates a TaskClient for provider authenticated by key,
+// sending its requests to baseURL through doer. An empty baseURL uses the
+// BaseURL of the provider and a nil doer DefaultDoer.
+func NewTaskClient(provider TaskProvider, key, baseURL string, doer Doer) *TaskClient {
+	if baseURL == "" {
+		baseURL = provider.BaseURL
+	}
+	if doer == nil {
+		doer = DefaultDoer
+	}
+	return &TaskClient{
+		Provider:  provider,
+		ClientKey: key,
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		Doer:      doer,
+	}
+}
+
+// taskResponse is the union of the responses of the createTask style API
+// methods.
+type taskResponse struct {
+	ErrorID          int                    `json:"errorId"`
+	ErrorCode        string                 `json:"errorCode"`
+	ErrorDescription string                 `json:"errorDescription"`
+	TaskID           json.RawMessage        `json:"taskId"`
+	Status           string                 `json:"status"`
+	Solution         map[string]interface{} `json:"solution"`
+	Balance          *float64               `json:"balance"`
+}
+
+// taskID returns the task id as a string. Providers answer with either a
+// number or a string.
+func (r *taskResponse) taskID() string {
+	return strings.Trim(string(r.TaskID), "\"")
+}
+
+// taskIDValue converts a task id back to the JSON type the provider used.
+func taskIDValue(id string) interface{} {
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return n
+	}
+	return id
+}
+
+// call sends body with the client key to the given API method.
+func (c *TaskClient) call(ctx context.Context, method string, body map[string]interface{}) (*taskResponse, error) {
+	body["clientKey"] = c.ClientKey
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/"+method, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.Doer.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrProviderDown, err)
+	}
+	defer resp.Body.Close()
+
+	r := &taskResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrProviderDown, c.Provider.Name, err)
+	}
+	if r.ErrorID != 0 {
+		return nil, newProviderError(c.Provider.Name, r.ErrorCode)
+	}
+	return r, nil
+}
+
+// CreateTask submits task and returns its id.
+func (c *TaskClient) CreateTask(ctx context.Context, task map[string]interface{}) (string, error) {
+	r, err := c.call(ctx, "createTask", map[string]interface{}{"task": task})
+	if err != nil {
+		return "", err
+	}
+	id := r.taskID()
+	if id == "" || id == "0" || id == "null" {
+		return "", fmt.Errorf("%w: %s: task number not found in server response", ErrProviderDown, c.Provider.Name)
+	}
+	return id, nil
+}
+
+// GetTaskResult polls the task until it is ready and returns its solution.
+func (c *TaskClient) GetTaskResult(ctx context.Context, id string) (map[string]interface{}, error) {
+	var solution map[string]interface{}
+	err := c.Provider.Poll.poll(ctx, c.Provider.Name, func() (bool, error) {
+		r, err := c.call(ctx, "getTaskResult", map[string]interface{}{"taskId": taskIDValue(id)})
+		if err != nil {
+			return false, err
+		}
+		solution = r.Solution
+		return r.Status == "ready", nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return solution, nil
+}
+
+// SolveTask solves the reCAPTCHA or hCaptcha described by task. A nil proxy,
+// or a kind the provider only solves proxyless, submits the proxyless
+// variant of the task.
+func (c *TaskClient) SolveTask(ctx context.Context, task Task, proxy *Proxy) (*Token, error) {
+	body, err := c.newTask(task, proxy)
+	if err != nil {
+		return nil, err
+	}
+	id, err := c.CreateTask(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	solution, err := c.GetTaskResult(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	token, _ := solution["gRecaptchaResponse"].(string)
+	if token == "" {
+		return nil, fmt.Errorf("%w: %s: empty solution", ErrProviderDown, c.Provider.Name)
+	}
+	return newAPIToken(c.Provider.Name, id, token), nil
+}
+
+// SolveImage solves the image captcha read from r and returns the
+// recognized text.
+func (c *TaskClient) SolveImage(ctx context.Context, r io.Reader, opts ImageOptions) (*Token, error) {
+	if c.Provider.ImageTask == "" {
+		return nil, &ProviderError{Provider: c.Provider.Name, Code: "image", Err: ErrUnsupportedTask}
+	}
+	body, err := readImage(r)
+	if err != nil {
+		return nil, err
+	}
+	task := map[string]interface{}{
+		"type": c.Provider.ImageTask,
+		"body": body,
+	}
+	if opts.CaseSensitive {
+		task["case"] = true
+	}
+	if opts.Numeric {
+		task["numeric"] = 1
+	}
+	if opts.MinLength > 0 {
+		task["minLength"] = opts.MinLength
+	}
+	if opts.MaxLength > 0 {
+		task["maxLength"] = opts.MaxLength
+	}
+	if opts.Language != "" {
+		task["languagePool"] = opts.Language
+	}
+	id, err := c.CreateTask(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	solution, err := c.GetTaskResult(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	text, _ := solution["text"].(string)
+	if text == "" {
+		return nil, fmt.Errorf("%w: %s: empty solution", ErrProviderDown, c.Provider.Name)
+	}
+	return newAPIToken(c.Provider.Name, id, text), nil
+}
+
+// newTask builds the createTask payload of task.
+func (c *TaskClient) newTask(task Task, proxy *Proxy) (map[string]interface{}, error) {
+	types, ok := c.Provider.TaskTypes[task.kind()]
+	if !ok {
+		return nil, &ProviderError{Provider: c.Provider.Name, Code: string(task.kind()), Err: ErrUnsupportedTask}
+	}
+	body := map[string]interface{}{
+		"type":       types.Proxyless,
+		"websiteURL": task.PageURL,
+		"websiteKey": task.SiteKey,
+	}
+	switch task.kind() {
+	case RecaptchaV2Invisible:
+		body["isInvisible"] = true
+	case RecaptchaV3:
+		body["pageAction"] = task.Action
+		if task.MinScore > 0 {
+			body["minScore"] = task.MinScore
+		}
+	case RecaptchaEnterprise:
+		if len(task.EnterprisePayload) > 0 {
+			body["enterprisePayload"] = task.EnterprisePayload
+		}
+	}
+	if proxy != nil && types.Proxy != "" {
+		body["type"] = types.Proxy
+		body["proxyType"] = string(proxy.proxyType())
+		body["proxyAddress"] = proxy.Host
+		body["proxyPort"] = proxy.Port
+		body["proxyLogin"] = proxy.Username
+		body["proxyPassword"] = proxy.Password
+	}
+	for k, v := range task.extra() {
+		body[k] = v
+	}
+	return body, nil
+}
+
+// Balance returns the balance of the account.
+func (c *TaskClient) Balance(ctx context.Context) (float64, error) {
+	r, err := c.call(ctx, "getBalance", map[string]interface{}{})
+	if err != nil {
+		return 0, err
+	}
+	if r.Balance == nil {
+		return 0, fmt.Errorf("%w: %s: balance not found in server response", ErrProviderDown, c.Provider.Name)
+	}
+	return *r.Balance, nil
+}
+
+// Report tells the provider whether the token of the given task was
+// accepted. Reports the provider does not support are ignored.
+func (c *TaskClient) Report(ctx context.Context, id string, ok bool) error {
+	method := c.Provider.ReportIncorrectMethod
+	if ok {
+		method = c.Provider.ReportCorrectMethod
+	}
+	if method == "" {
+		return nil
+	}
+	_, err := c.call(ctx, method, map[string]interface{}{"taskId": taskIDValue(id)})
+	return err
+}
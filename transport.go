@@ -0,0 +1,61 @@
+package solver
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	connect "bitbucket.org/babylonaio/pkg/http"
+)
+
+// Doer sends the HTTP requests of a provider client. *http.Client
+// implements it, so per-client timeouts come from its Timeout.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DoerFunc adapts a function to a Doer.
+type DoerFunc func(req *http.Request) (*http.Response, error)
+
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// DefaultDoer sends requests through the shared connect client. It is used
+// by clients created without a Doer.
+var DefaultDoer Doer = DoerFunc(connect.DefaultDo)
+
+// RateLimit returns a Doer sending at most one request per interval through
+// doer. Requests waiting for their turn give up when their context is done.
+func RateLimit(doer Doer, interval time.Duration) Doer {
+	return &rateLimiter{doer: doer, interval: interval}
+}
+
+type rateLimiter struct {
+	doer     Doer
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+func (r *rateLimiter) Do(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait := at.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+	return r.doer.Do(req)
+}
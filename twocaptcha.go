@@ -3,21 +3,21 @@ package solver
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
-
-	connect "bitbucket.org/babylonaio/pkg/http"
 )
 
-// ApiURL is the url of the 2captcha API endpoint
-var ApiURL = "https://2captcha.com/in.php"
+// TwoCaptchaURL is the base url of the 2captcha API, serving in.php and
+// res.php.
+const TwoCaptchaURL = "https://2captcha.com"
 
-// ResultURL is the url of the 2captcha result API endpoint
-var ResultURL = "https://2captcha.com/res.php"
+const twoCaptchaName = "2captcha"
 
 // TwoCaptchaClient is an interface to https://2captcha.com/ API.
 type TwoCaptchaClient struct {
@@ -25,102 +25,214 @@ type TwoCaptchaClient struct {
 	// Valid key is required by all the functions of this library
 	// See more details on https://2captcha.com/2captcha-api#solving_captchas
 	ApiKey string
+	// BaseURL is the url in.php and res.php are relative to.
+	BaseURL string
+	Doer    Doer
+	// Poll schedules the res.php calls of a captcha.
+	Poll PollPolicy
+}
+
+// TwoCaptchaPoll polls every 3 seconds for up to a minute.
+var TwoCaptchaPoll = PollPolicy{
+	Initial:  3 * time.Second,
+	Interval: 3 * time.Second,
+	MaxWait:  60 * time.Second,
+	Jitter:   500 * time.Millisecond,
 }
 
-// New creates a TwoCaptchaClient instance
+// NewTwoCaptcha creates a TwoCaptchaClient for the 2captcha API.
 func NewTwoCaptcha(apiKey string) *TwoCaptchaClient {
+	return NewTwoCaptchaClient(apiKey, TwoCaptchaURL, nil)
+}
+
+// NewTwoCaptchaClient creates a TwoCaptchaClient sending its requests to
+// baseURL through doer. An empty baseURL uses TwoCaptchaURL and a nil doer
+// uses DefaultDoer.
+func NewTwoCaptchaClient(apiKey, baseURL string, doer Doer) *TwoCaptchaClient {
+	if baseURL == "" {
+		baseURL = TwoCaptchaURL
+	}
+	if doer == nil {
+		doer = DefaultDoer
+	}
 	return &TwoCaptchaClient{
-		ApiKey: apiKey,
+		ApiKey:  apiKey,
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Doer:    doer,
+		Poll:    TwoCaptchaPoll,
 	}
 }
 
-// SolveCaptcha performs a normal captcha solving request to 2captcha.com
-// and returns with the solved captcha if the request was successful.
+// SolveImage performs a normal captcha solving request to 2captcha.com
+// with the image read from r and returns with the recognized text if the
+// request was successful.
 // Valid ApiKey is required.
 // See more details on https://2captcha.com/2captcha-api#solving_normal_captcha
-//func (c *TwoCaptchaClient) SolveCaptcha(url string) (string, error) {
-//
-//	req, _ := http.NewRequest("GET", url, nil)
-//	resp, err := connect.DefaultDo(req)
-//	if err != nil || resp.StatusCode != 200 {
-//		return "", err
-//	}
-//	defer resp.Body.Close()
-//
-//	body, err := ioutil.ReadAll(resp.Body)
-//	if err != nil {
-//		return "", err
-//	}
-//
-//	strBase64 := base64.StdEncoding.EncodeToString(body)
-//
-//	captchaId, err := c.apiRequest(nil,
-//		ApiURL,
-//		map[string]string{
-//			"method":   "base64",
-//			"body":     strBase64,
-//			"phrase":   "1",
-//			"regsense": "1",
-//		},
-//		0,
-//		3,
-//	)
-//
-//	if err != nil {
-//		return "", err
-//	}
-//
-//	return c.apiRequest(nil,
-//		ResultURL,
-//		map[string]string{
-//			"id":     captchaId,
-//			"action": "get",
-//		},
-//		5,
-//		20,
-//	)
-//}
+func (c *TwoCaptchaClient) SolveImage(ctx context.Context, r io.Reader, opts ImageOptions) (*Token, error) {
+	body, err := readImage(r)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{
+		"method": "base64",
+		"body":   body,
+	}
+	if opts.CaseSensitive {
+		params["regsense"] = "1"
+	}
+	if opts.Numeric {
+		params["numeric"] = "1"
+	}
+	if opts.MinLength > 0 {
+		params["min_len"] = strconv.Itoa(opts.MinLength)
+	}
+	if opts.MaxLength > 0 {
+		params["max_len"] = strconv.Itoa(opts.MaxLength)
+	}
+	if opts.Language != "" {
+		params["lang"] = opts.Language
+	}
+	return c.solve(ctx, params)
+}
 
 // SolveRecaptchaV2 performs a recaptcha v2 solving request to 2captcha.com
 // and returns with the solved captcha if the request was successful.
 // Valid ApiKey is required.
 // See more details on https://2captcha.com/2captcha-api#solving_recaptchav2_new
-func (c *TwoCaptchaClient) SolveRecaptchaV2(ctx context.Context, siteURL, recaptchaKey string) (string, error) {
-	captchaId, err := c.apiRequest(ctx,
-		ApiURL,
-		map[string]string{
-			"googlekey": recaptchaKey,
-			"pageurl":   siteURL,
-			"method":    "userrecaptcha",
-		},
-		0,
-		3,
-	)
-
-	log.Println(captchaId, err)
+// Extra parameters such as userAgent are sent along with the task.
+func (c *TwoCaptchaClient) SolveRecaptchaV2(ctx context.Context, siteURL, recaptchaKey string, extra map[string]string) (*Token, error) {
+	params := map[string]string{
+		"googlekey": recaptchaKey,
+		"pageurl":   siteURL,
+		"method":    "userrecaptcha",
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	return c.solve(ctx, params)
+}
+
+// SolveTask solves a reCAPTCHA v2, v3, Enterprise or hCaptcha described by
+// task, through proxy unless it is nil.
+// See more details on https://2captcha.com/2captcha-api#solving_recaptchav3
+// and https://2captcha.com/2captcha-api#solving_hcaptcha
+func (c *TwoCaptchaClient) SolveTask(ctx context.Context, task Task, proxy *Proxy) (*Token, error) {
+	params := map[string]string{
+		"googlekey": task.SiteKey,
+		"pageurl":   task.PageURL,
+		"method":    "userrecaptcha",
+	}
+	switch task.kind() {
+	case RecaptchaV2:
+	case RecaptchaV2Invisible:
+		params["invisible"] = "1"
+	case RecaptchaV3:
+		params["version"] = "v3"
+		params["action"] = task.Action
+		if task.MinScore > 0 {
+			params["min_score"] = strconv.FormatFloat(task.MinScore, 'f', -1, 64)
+		}
+	case RecaptchaEnterprise:
+		params["enterprise"] = "1"
+		if s, ok := task.EnterprisePayload["s"]; ok {
+			params["data-s"] = s
+		}
+	case HCaptcha:
+		delete(params, "googlekey")
+		params["method"] = "hcaptcha"
+		params["sitekey"] = task.SiteKey
+	default:
+		return nil, &ProviderError{Provider: twoCaptchaName, Code: string(task.Kind), Err: ErrUnsupportedTask}
+	}
+	if proxy != nil {
+		params["proxy"] = proxy.String()
+		params["proxytype"] = proxy.twoCaptchaType()
+	}
+	if task.UserAgent != "" {
+		params["userAgent"] = task.UserAgent
+	}
+	for k, v := range task.Params {
+		params[k] = v
+	}
+	return c.solve(ctx, params)
+}
+
+// solve submits params to in.php and polls res.php for the result
+func (c *TwoCaptchaClient) solve(ctx context.Context, params map[string]string) (*Token, error) {
+	captchaId, err := c.apiRequest(ctx, c.BaseURL+"/in.php", params)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return c.apiRequest(ctx,
-		ResultURL,
-		map[string]string{
-			"googlekey": recaptchaKey,
-			"pageurl":   siteURL,
-			"method":    "userrecaptcha",
-			"id":        captchaId,
-			"action":    "get",
-		},
-		3,
-		20,
-	)
+	var token string
+	err = c.Poll.poll(ctx, twoCaptchaName, func() (bool, error) {
+		token, err = c.apiRequest(ctx, c.BaseURL+"/res.php", map[string]string{
+			"id":     captchaId,
+			"action": "get",
+		})
+		if errors.Is(err, errNotReady) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newAPIToken(twoCaptchaName, captchaId, token), nil
+}
+
+// Report tells 2captcha whether the token of the captcha with the given id
+// was accepted, so a wrong solution can be refunded.
+// See more details on https://2captcha.com/2captcha-api#complain
+func (c *TwoCaptchaClient) Report(ctx context.Context, id string, ok bool) error {
+	action := "reportbad"
+	if ok {
+		action = "reportgood"
+	}
+	body, err := c.post(ctx, c.BaseURL+"/res.php", map[string]string{"action": action, "id": id})
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(body, "OK_REPORT_RECORDED") {
+		return newProviderError(twoCaptchaName, body)
+	}
+	return nil
 }
 
-func (c *TwoCaptchaClient) apiRequest(ctx context.Context, URL string, params map[string]string, delay time.Duration, retries int) (string, error) {
-	if retries <= 0 {
-		return "", errors.New("Maximum retries exceeded")
+// Balance returns the balance of the 2captcha account.
+// See more details on https://2captcha.com/2captcha-api#additional-methods
+func (c *TwoCaptchaClient) Balance(ctx context.Context) (float64, error) {
+	body, err := c.post(ctx, c.BaseURL+"/res.php", map[string]string{"action": "getbalance"})
+	if err != nil {
+		return 0, err
 	}
-	time.Sleep(delay * time.Second)
+	balance, err := strconv.ParseFloat(strings.TrimSpace(body), 64)
+	if err != nil {
+		return 0, newProviderError(twoCaptchaName, body)
+	}
+	return balance, nil
+}
+
+// errNotReady is returned by apiRequest while the captcha is being solved.
+var errNotReady = errors.New("CAPCHA_NOT_READY")
+
+// apiRequest posts params to URL and returns the value of an "OK|" answer.
+func (c *TwoCaptchaClient) apiRequest(ctx context.Context, URL string, params map[string]string) (string, error) {
+	body, err := c.post(ctx, URL, params)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(body, "CAPCHA_NOT_READY") {
+		return "", errNotReady
+	}
+	if !strings.HasPrefix(body, "OK|") {
+		return "", newProviderError(twoCaptchaName, body)
+	}
+	return body[3:], nil
+}
+
+// post sends params along with the API key and returns the raw response body
+func (c *TwoCaptchaClient) post(ctx context.Context, URL string, params map[string]string) (string, error) {
 	form := url.Values{}
 	form.Add("key", c.ApiKey)
 	for k, v := range params {
@@ -133,21 +245,17 @@ func (c *TwoCaptchaClient) apiRequest(ctx context.Context, URL string, params ma
 	}
 
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := connect.DefaultDo(req)
+	resp, err := c.Doer.Do(req)
 	if err != nil {
-		return "", err
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%w: %v", ErrProviderDown, err)
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %v", ErrProviderDown, err)
 	}
-	log.Println(string(body), err)
 	resp.Body.Close()
-	if strings.Contains(string(body), "CAPCHA_NOT_READY") {
-		return c.apiRequest(ctx, URL, params, delay, retries-1)
-	}
-	if !strings.Contains(string(body), "OK|") {
-		return "", errors.New("Invalid respponse from 2captcha: " + string(body))
-	}
-	return string(body[3:]), nil
+	return string(body), nil
 }
@@ -0,0 +1,198 @@
+package solver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the WindowScheduler so tests can drive it.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the Clock of the running system.
+var SystemClock Clock = systemClock{}
+
+// HarvestWindow asks for the pool of Task to hold at least Size tokens from
+// Start to End, for example just before and during a release.
+type HarvestWindow struct {
+	Task  Task
+	Start time.Time
+	End   time.Time
+	Size  int32
+	// Workers is the number of solves the pool may run at once during the
+	// window. Zero uses Size.
+	Workers int
+}
+
+// WindowScheduler harvests during scheduled windows only. It starts the
+// bank when a window opens, raises the MinSize and Workers of the pools
+// of the open windows, and calls Stop once the last window closed.
+type WindowScheduler struct {
+	bank  *CaptchaBank
+	clock Clock
+
+	mu      sync.Mutex
+	windows []HarvestWindow
+	wake    chan struct{}
+	// saved holds the pools raised by a window, by task key, with the config
+	// they had before.
+	saved   map[string]PoolSnapshot
+	started bool
+}
+
+// NewWindowScheduler creates a scheduler for bank. A nil clock uses
+// SystemClock.
+func NewWindowScheduler(bank *CaptchaBank, clock Clock) *WindowScheduler {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &WindowScheduler{
+		bank:  bank,
+		clock: clock,
+		wake:  make(chan struct{}, 1),
+		saved: map[string]PoolSnapshot{},
+	}
+}
+
+// Add schedules w.
+func (s *WindowScheduler) Add(w HarvestWindow) {
+	s.mu.Lock()
+	s.windows = append(s.windows, w)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Trigger opens a window for task right away, for drops that were not
+// scheduled in advance.
+func (s *WindowScheduler) Trigger(task Task, size int32, d time.Duration) {
+	now := s.clock.Now()
+	s.Add(HarvestWindow{Task: task, Start: now, End: now.Add(d), Size: size})
+}
+
+// Upcoming returns the windows that have not closed yet, by start time.
+func (s *WindowScheduler) Upcoming() []HarvestWindow {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var windows []HarvestWindow
+	for _, w := range s.windows {
+		if now.Before(w.End) {
+			windows = append(windows, w)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows
+}
+
+// Run applies the windows as they open and close until ctx is done, then
+// restores the pools and stops the harvest it started.
+func (s *WindowScheduler) Run(ctx context.Context) {
+	for {
+		next := s.apply()
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = s.clock.After(next.Sub(s.clock.Now()))
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.windows = nil
+			s.mu.Unlock()
+			s.apply()
+			return
+		case <-s.wake:
+		case <-timer:
+		}
+	}
+}
+
+// apply configures the pools for the windows open now, drops the closed
+// ones, and returns when the next window opens or closes, zero if none. The
+// bank is only stopped if the scheduler started it.
+func (s *WindowScheduler) apply() time.Time {
+	now := s.clock.Now()
+	s.mu.Lock()
+
+	var next time.Time
+	sizes := map[string]int32{}
+	workers := map[string]int{}
+	tasks := map[string]Task{}
+	windows := s.windows[:0]
+	for _, w := range s.windows {
+		if !now.Before(w.End) {
+			continue
+		}
+		windows = append(windows, w)
+		edge := w.End
+		if now.Before(w.Start) {
+			edge = w.Start
+		} else {
+			key := w.Task.Key()
+			if size, ok := sizes[key]; !ok || w.Size > size {
+				sizes[key] = w.Size
+				tasks[key] = w.Task
+			}
+			n := w.Workers
+			if n <= 0 {
+				n = int(w.Size)
+			}
+			if n > workers[key] {
+				workers[key] = n
+			}
+		}
+		if next.IsZero() || edge.Before(next) {
+			next = edge
+		}
+	}
+	s.windows = windows
+
+	for key, saved := range s.saved {
+		if _, ok := sizes[key]; !ok {
+			s.bank.AddPool(saved.Task, saved.Config)
+			delete(s.saved, key)
+		}
+	}
+	for key, size := range sizes {
+		if _, ok := s.saved[key]; !ok {
+			p := s.bank.getPool(tasks[key], true)
+			s.saved[key] = PoolSnapshot{Task: p.task, Config: p.config()}
+		}
+		cfg := s.saved[key].Config
+		cfg.MinSize = size
+		if cfg.MaxSize < size {
+			cfg.MaxSize = size
+		}
+		if cfg.Workers < workers[key] {
+			cfg.Workers = workers[key]
+		}
+		s.bank.AddPool(tasks[key], cfg)
+	}
+
+	stop := false
+	switch {
+	case len(sizes) > 0 && !s.started:
+		s.started = s.bank.Start()
+	case len(sizes) == 0 && s.started:
+		s.started = false
+		stop = true
+	}
+	s.mu.Unlock()
+
+	// Stop waits for the workers to drain, which must not block Add.
+	if stop {
+		s.bank.Stop(context.Background())
+	}
+	return next
+}
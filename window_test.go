@@ -0,0 +1,116 @@
+package solver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/babylonaio/pkg/solver/solvertest"
+)
+
+// fakeClock is a Clock that only moves when set. Its timers never fire, the
+// tests call apply themselves.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time { return nil }
+
+func (c *fakeClock) set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+func TestWindowOpensAndCloses(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{})
+	defer s.Close()
+	cfg := PoolConfig{MaxSize: 1, Workers: 1}
+	c := newTestBank(t, s, cfg)
+	t0 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: t0}
+	sched := NewWindowScheduler(c, clock)
+	p := c.getPool(testTask, false)
+
+	sched.Add(HarvestWindow{Task: testTask, Start: t0.Add(time.Minute), End: t0.Add(2 * time.Minute), Size: 3})
+	if next := sched.apply(); !next.Equal(t0.Add(time.Minute)) {
+		t.Errorf("next = %v, want the window start", next)
+	}
+	if c.Running() {
+		t.Error("bank started before the window opened")
+	}
+
+	clock.set(t0.Add(time.Minute))
+	if next := sched.apply(); !next.Equal(t0.Add(2 * time.Minute)) {
+		t.Errorf("next = %v, want the window end", next)
+	}
+	if !c.Running() {
+		t.Error("bank not started when the window opened")
+	}
+	if got, want := p.config(), (PoolConfig{MinSize: 3, MaxSize: 3, Workers: 3}); got != want {
+		t.Errorf("open window config = %+v, want %+v", got, want)
+	}
+
+	clock.set(t0.Add(2 * time.Minute))
+	if next := sched.apply(); !next.IsZero() {
+		t.Errorf("next = %v, want none", next)
+	}
+	if c.Running() {
+		t.Error("bank still running after the window closed")
+	}
+	if got := p.config(); got != cfg {
+		t.Errorf("closed window config = %+v, want %+v", got, cfg)
+	}
+	if len(sched.Upcoming()) != 0 {
+		t.Error("closed window still upcoming")
+	}
+}
+
+func TestWindowKeepsCallerBankRunning(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{})
+	defer s.Close()
+	c := newTestBank(t, s, PoolConfig{MaxSize: 1, Workers: 1})
+	t0 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: t0}
+	sched := NewWindowScheduler(c, clock)
+
+	c.Start()
+	sched.Trigger(testTask, 2, time.Minute)
+	sched.apply()
+	clock.set(t0.Add(time.Minute))
+	sched.apply()
+	if !c.Running() {
+		t.Error("scheduler stopped a bank it did not start")
+	}
+}
+
+func TestWindowWorkers(t *testing.T) {
+	s := solvertest.NewServer(solvertest.Config{})
+	defer s.Close()
+	cfg := PoolConfig{MaxSize: 30, Workers: 1}
+	c := newTestBank(t, s, cfg)
+	t0 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: t0}
+	sched := NewWindowScheduler(c, clock)
+	p := c.getPool(testTask, false)
+
+	sched.Add(HarvestWindow{Task: testTask, Start: t0, End: t0.Add(time.Minute), Size: 20, Workers: 8})
+	sched.Add(HarvestWindow{Task: testTask, Start: t0, End: t0.Add(time.Minute), Size: 5, Workers: 12})
+	sched.apply()
+	if got, want := p.config(), (PoolConfig{MinSize: 20, MaxSize: 30, Workers: 12}); got != want {
+		t.Errorf("open window config = %+v, want %+v", got, want)
+	}
+
+	clock.set(t0.Add(time.Minute))
+	sched.apply()
+	if got := p.config(); got != cfg {
+		t.Errorf("closed window config = %+v, want %+v", got, cfg)
+	}
+}